@@ -0,0 +1,402 @@
+package statemachine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidDefinition 状态机定义不合法
+var ErrInvalidDefinition = NewError("invalid state machine definition")
+
+// Definition 声明式的状态机定义，状态和事件使用字符串表示，加载时通过 FunctionRegistry 解析
+type Definition struct {
+	// States 声明的状态，不为空时流转只能使用声明过的状态
+	States      []string               `json:"states,omitempty" yaml:"states,omitempty"`
+	Initial     string                 `json:"initial,omitempty" yaml:"initial,omitempty"`
+	Finals      []string               `json:"finals,omitempty" yaml:"finals,omitempty"`
+	Transitions []TransitionDefinition `json:"transitions" yaml:"transitions"`
+}
+
+// TransitionDefinition 一个流转的定义，Type 区分大小写，只能是 EXTERNAL 或 INTERNAL，为空时是外部流转，内部流转的 To 可以为空
+// 定义不支持复合状态，所以也不支持本地流转
+type TransitionDefinition struct {
+	From  Sources `json:"from" yaml:"from"`
+	To    string  `json:"to,omitempty" yaml:"to,omitempty"`
+	Event string  `json:"event" yaml:"event"`
+	Type  string  `json:"type,omitempty" yaml:"type,omitempty"`
+	// After 超时流转的时间，格式同 time.ParseDuration，例如 30m
+	After  string `json:"after,omitempty" yaml:"after,omitempty"`
+	Guard  string `json:"guard,omitempty" yaml:"guard,omitempty"`
+	Action string `json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+// Sources 流转的源状态，只有一个源状态时可以写成字符串
+type Sources []string
+
+func (s Sources) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return json.Marshal(s[0])
+	}
+	return json.Marshal([]string(s))
+}
+
+func (s *Sources) UnmarshalJSON(data []byte) error {
+	var source string
+	if err := json.Unmarshal(data, &source); err == nil {
+		*s = Sources{source}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(s))
+}
+
+// DefinitionProblem 定义中的一个问题，Path 是出错的字段，例如 transitions[1].guard，Line 为 0 表示行号未知
+type DefinitionProblem struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (p *DefinitionProblem) Error() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", p.Line, p.Path, p.Msg)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Msg)
+}
+
+// DefinitionError 定义中的所有问题，存在问题时不会注册任何流转
+type DefinitionError struct {
+	Problems []*DefinitionProblem
+}
+
+func (e *DefinitionError) Error() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("invalid state machine definition with %d problems:", len(e.Problems)))
+	for _, problem := range e.Problems {
+		builder.WriteString("\n  - " + problem.Error())
+	}
+	return builder.String()
+}
+
+func (e *DefinitionError) Unwrap() []error {
+	res := make([]error, 0, len(e.Problems)+1)
+	res = append(res, ErrInvalidDefinition)
+	for _, problem := range e.Problems {
+		res = append(res, problem)
+	}
+	return res
+}
+
+// FunctionRegistry 按名字注册定义中使用的条件和动作，以及把字符串解析成状态和事件的方法
+type FunctionRegistry[S, E ID, C any] struct {
+	guards     map[string]ContextCondition[C]
+	actions    map[string]ContextAction[S, E, C]
+	parseState func(s string) (S, error)
+	parseEvent func(s string) (E, error)
+}
+
+// NewFunctionRegistry 创建一个空的 FunctionRegistry，默认按字面值解析状态和事件，例如 "1" 解析成 1
+func NewFunctionRegistry[S, E ID, C any]() *FunctionRegistry[S, E, C] {
+	return &FunctionRegistry[S, E, C]{
+		guards:     make(map[string]ContextCondition[C]),
+		actions:    make(map[string]ContextAction[S, E, C]),
+		parseState: parseID[S],
+		parseEvent: parseID[E],
+	}
+}
+
+// RegisterGuard 注册名字为 name 的条件
+func (r *FunctionRegistry[S, E, C]) RegisterGuard(name string, condition Condition[C]) {
+	r.guards[name] = func(_ context.Context, c C) bool {
+		return condition(c)
+	}
+}
+
+// RegisterGuardContext 注册名字为 name 的 context.Context 条件
+func (r *FunctionRegistry[S, E, C]) RegisterGuardContext(name string, condition ContextCondition[C]) {
+	r.guards[name] = condition
+}
+
+// RegisterAction 注册名字为 name 的动作
+func (r *FunctionRegistry[S, E, C]) RegisterAction(name string, action Action[S, E, C]) {
+	r.actions[name] = func(_ context.Context, from S, to S, event E, c C) error {
+		return action(from, to, event, c)
+	}
+}
+
+// RegisterActionContext 注册名字为 name 的 context.Context 动作
+func (r *FunctionRegistry[S, E, C]) RegisterActionContext(name string, action ContextAction[S, E, C]) {
+	r.actions[name] = action
+}
+
+// SetStateParser 设置把字符串解析成状态的方法，状态使用名字表示时可以使用 NameParser
+func (r *FunctionRegistry[S, E, C]) SetStateParser(parse func(s string) (S, error)) {
+	r.parseState = parse
+}
+
+// SetEventParser 设置把字符串解析成事件的方法，事件使用名字表示时可以使用 NameParser
+func (r *FunctionRegistry[S, E, C]) SetEventParser(parse func(s string) (E, error)) {
+	r.parseEvent = parse
+}
+
+// NameParser 返回按 fmt.Sprint 得到的名字解析 values 的方法，适用于实现了 String 方法的枚举，
+// 同时接受 Export 导出的字面值
+func NameParser[T ID](values ...T) func(s string) (T, error) {
+	names := make(map[string]T, 2*len(values))
+	for _, value := range values {
+		names[fmt.Sprint(value)] = value
+	}
+	for _, value := range values {
+		if _, ok := names[formatID(value)]; !ok {
+			names[formatID(value)] = value
+		}
+	}
+	return func(s string) (r T, err error) {
+		value, ok := names[s]
+		if !ok {
+			return r, fmt.Errorf("unknown name %q", s)
+		}
+		return value, nil
+	}
+}
+
+// formatID 按 T 的底层类型格式化，是 parseID 的逆操作，不使用 T 的 String 方法
+func formatID[T ID](id T) string {
+	v := reflect.ValueOf(id)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+	return v.String()
+}
+
+// parseID 按 T 的底层类型解析字符串，ID 只包含字符串和整数类型
+func parseID[T ID](s string) (r T, err error) {
+	v := reflect.ValueOf(&r).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(s, 10, v.Type().Bits()); err == nil {
+			v.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(s, 10, v.Type().Bits()); err == nil {
+			v.SetUint(n)
+		}
+	}
+	return r, err
+}
+
+// LoadJSON 从 JSON 文档加载状态机定义，见 LoadDefinition
+func (b *Builder[S, E, C]) LoadJSON(data []byte, functions *FunctionRegistry[S, E, C]) error {
+	definition := &Definition{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(definition); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDefinition, err)
+	}
+	return b.LoadDefinition(definition, functions)
+}
+
+// LoadDefinition 把定义中的起始状态、终止状态和流转加载到 Builder 中，条件和动作的名字通过 functions 解析
+// 定义中有问题时返回包含所有问题的 DefinitionError，不会注册任何流转
+func (b *Builder[S, E, C]) LoadDefinition(definition *Definition, functions *FunctionRegistry[S, E, C]) error {
+	return b.loadDefinition(definition, functions, nil)
+}
+
+// loadDefinition 加载定义，lines 是字段路径对应的行号，用于在问题中报告行号
+func (b *Builder[S, E, C]) loadDefinition(definition *Definition, functions *FunctionRegistry[S, E, C], lines map[string]int) error {
+	if functions == nil {
+		functions = NewFunctionRegistry[S, E, C]()
+	}
+	loader := &definitionLoader[S, E, C]{functions: functions, states: make(map[string]S), lines: lines}
+	transitions := loader.resolve(definition)
+	if len(loader.problems) != 0 {
+		return &DefinitionError{Problems: loader.problems}
+	}
+	for _, stateId := range loader.states {
+		b.stateMachine.createAndGetState(stateId)
+	}
+	if definition.Initial != "" {
+		b.InitialState(loader.states[definition.Initial])
+	}
+	for _, final := range definition.Finals {
+		b.FinalStates(loader.states[final])
+	}
+	for _, t := range transitions {
+		t.register(b.stateMachine)
+	}
+	return nil
+}
+
+// resolvedTransition 解析完成等待注册的流转
+type resolvedTransition[S, E ID, C any] struct {
+	ty            TransitionType
+	sources       []S
+	to            S
+	event         E
+	after         time.Duration
+	condition     ContextCondition[C]
+	action        ContextAction[S, E, C]
+	conditionName string
+	actionName    string
+}
+
+func (t *resolvedTransition[S, E, C]) register(stateMachine *stateMachine[S, E, C]) {
+	// 内部流转的每个源状态单独注册
+	if t.ty == INTERNAL {
+		for _, source := range t.sources {
+			t.on(newTransitionBuilder[S, E, C](stateMachine, t.ty).Within(source).(*transitionBuilder[S, E, C]))
+		}
+		return
+	}
+	t.on(newTransitionBuilder[S, E, C](stateMachine, t.ty).From(t.sources...).To(t.to).(*transitionBuilder[S, E, C]))
+}
+
+func (t *resolvedTransition[S, E, C]) on(builder *transitionBuilder[S, E, C]) {
+	if t.after > 0 {
+		builder.After(t.after, t.event)
+	} else {
+		builder.On(t.event)
+	}
+	builder.WhenContext(t.condition).PerformContext(t.action)
+	for _, transition := range builder.transitions {
+		transition.conditionName = t.conditionName
+		transition.actionName = t.actionName
+	}
+}
+
+// definitionLoader 解析定义并收集其中的问题
+type definitionLoader[S, E ID, C any] struct {
+	functions *FunctionRegistry[S, E, C]
+	// states 定义中使用的所有状态，declared 为 true 时只能使用 states 中声明过的状态
+	states   map[string]S
+	declared bool
+	problems []*DefinitionProblem
+	// lines 字段路径对应的行号，只有 YAML 格式可以提供
+	lines map[string]int
+}
+
+func (l *definitionLoader[S, E, C]) addProblem(path string, format string, args ...any) {
+	l.problems = append(l.problems, &DefinitionProblem{Path: path, Line: l.lines[path], Msg: fmt.Sprintf(format, args...)})
+}
+
+func (l *definitionLoader[S, E, C]) resolve(definition *Definition) []*resolvedTransition[S, E, C] {
+	for k, name := range definition.States {
+		l.parseState(fmt.Sprintf("states[%d]", k), name)
+	}
+	l.declared = len(definition.States) != 0
+	if definition.Initial != "" {
+		l.state("initial", definition.Initial)
+	}
+	for k, name := range definition.Finals {
+		l.state(fmt.Sprintf("finals[%d]", k), name)
+	}
+	var res []*resolvedTransition[S, E, C]
+	for k := range definition.Transitions {
+		if t := l.resolveTransition(fmt.Sprintf("transitions[%d]", k), &definition.Transitions[k]); t != nil {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func (l *definitionLoader[S, E, C]) resolveTransition(path string, definition *TransitionDefinition) *resolvedTransition[S, E, C] {
+	count := len(l.problems)
+	t := &resolvedTransition[S, E, C]{conditionName: definition.Guard, actionName: definition.Action}
+	switch definition.Type {
+	case "", EXTERNAL.String():
+		t.ty = EXTERNAL
+	case INTERNAL.String():
+		t.ty = INTERNAL
+	default:
+		l.addProblem(path+".type", "unknown transition type %q", definition.Type)
+	}
+	if len(definition.From) == 0 {
+		l.addProblem(path+".from", "source state is required")
+	}
+	for k, name := range definition.From {
+		sourcePath := path + ".from"
+		if len(definition.From) > 1 {
+			sourcePath = fmt.Sprintf("%s.from[%d]", path, k)
+		}
+		source, _ := l.state(sourcePath, name)
+		t.sources = append(t.sources, source)
+	}
+	switch {
+	case definition.To != "":
+		t.to, _ = l.state(path+".to", definition.To)
+		if t.ty == INTERNAL && (len(definition.From) != 1 || definition.To != definition.From[0]) {
+			l.addProblem(path+".to", "internal transition can not change state, remove target state")
+		}
+	case t.ty == INTERNAL:
+	default:
+		l.addProblem(path+".to", "target state is required")
+	}
+	if definition.Event == "" {
+		l.addProblem(path+".event", "event is required")
+	} else if event, err := l.functions.parseEvent(definition.Event); err != nil {
+		l.addProblem(path+".event", "can not parse %q as event: %v", definition.Event, err)
+	} else {
+		t.event = event
+	}
+	if definition.After != "" {
+		after, err := time.ParseDuration(definition.After)
+		if err != nil || after <= 0 {
+			l.addProblem(path+".after", "invalid duration %q", definition.After)
+		}
+		t.after = after
+	}
+	if definition.Guard != "" {
+		if t.condition = l.functions.guards[definition.Guard]; t.condition == nil {
+			l.addProblem(path+".guard", "unknown guard %q", definition.Guard)
+		}
+	}
+	if definition.Action != "" {
+		if t.action = l.functions.actions[definition.Action]; t.action == nil {
+			l.addProblem(path+".action", "unknown action %q", definition.Action)
+		}
+	}
+	if len(l.problems) != count {
+		return nil
+	}
+	return t
+}
+
+// state 解析流转中使用的状态
+func (l *definitionLoader[S, E, C]) state(path string, name string) (S, bool) {
+	if stateId, ok := l.states[name]; ok {
+		return stateId, true
+	}
+	if l.declared {
+		var r S
+		l.addProblem(path, "undeclared state %q", name)
+		return r, false
+	}
+	return l.parseState(path, name)
+}
+
+func (l *definitionLoader[S, E, C]) parseState(path string, name string) (S, bool) {
+	if name == "" {
+		var r S
+		l.addProblem(path, "state is required")
+		return r, false
+	}
+	stateId, err := l.functions.parseState(name)
+	if err != nil {
+		l.addProblem(path, "can not parse %q as state: %v", name, err)
+		return stateId, false
+	}
+	l.states[name] = stateId
+	return stateId, true
+}
@@ -0,0 +1,412 @@
+package statemachine
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func newTestFunctionRegistry(records *[]string) *FunctionRegistry[States, Events, *Entity] {
+	functions := NewFunctionRegistry[States, Events, *Entity]()
+	functions.SetStateParser(NameParser[States](STATE1, STATE2, STATE3, STATE4))
+	functions.SetEventParser(NameParser[Events](EVENT1, EVENT2, EVENT3, EVENT4))
+	functions.RegisterGuard("isPaid", func(c *Entity) bool {
+		return c.Status == STATE1
+	})
+	functions.RegisterAction("notify", func(from States, to States, event Events, c *Entity) error {
+		*records = append(*records, from.String()+"->"+to.String())
+		return nil
+	})
+	return functions
+}
+
+func Test_loadJSON(t *testing.T) {
+	var records []string
+	builder := NewBuilder[States, Events, *Entity]()
+	err := builder.LoadJSON([]byte(`{
+		"initial": "STATE1",
+		"finals": ["STATE3"],
+		"transitions": [
+			{"from": "STATE1", "to": "STATE2", "event": "EVENT1", "guard": "isPaid", "action": "notify"},
+			{"from": "STATE2", "event": "EVENT4", "type": "INTERNAL", "action": "notify"},
+			{"from": "STATE2", "to": "STATE3", "event": "EVENT2"}
+		]
+	}`), newTestFunctionRegistry(&records))
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine, err := builder.BuildUnregistered("TestStateMachine-loadJSON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initial, ok := machine.InitialState(); !ok || initial != STATE1 || !machine.IsFinal(STATE3) {
+		t.Errorf("InitialState() = %v, IsFinal(STATE3) = %v", initial, machine.IsFinal(STATE3))
+	}
+	if target, _ := machine.FireEvent(STATE1, EVENT1, &Entity{Status: STATE4}); target != STATE1 {
+		t.Errorf("FireEvent() = %v, want %v", target, STATE1)
+	}
+	for _, step := range []struct {
+		from, to States
+		event    Events
+	}{{STATE1, STATE2, EVENT1}, {STATE2, STATE2, EVENT4}, {STATE2, STATE3, EVENT2}} {
+		if target, err := machine.FireEvent(step.from, step.event, &Entity{Status: step.from}); err != nil || target != step.to {
+			t.Errorf("FireEvent(%v, %v) = %v, %v, want %v", step.from, step.event, target, err, step.to)
+		}
+	}
+	if want := []string{"STATE1->STATE2", "STATE2->STATE2"}; !reflect.DeepEqual(records, want) {
+		t.Errorf("actions = %v, want %v", records, want)
+	}
+	if mermaid := machine.GenerateMermaid(); !strings.Contains(mermaid, "STATE1 --> STATE2 : EVENT1 [isPaid]") {
+		t.Errorf("GenerateMermaid() = %s", mermaid)
+	}
+}
+
+func Test_loadJSONError(t *testing.T) {
+	var records []string
+	builder := NewBuilder[States, Events, *Entity]()
+	err := builder.LoadJSON([]byte(`{
+		"transitions": [
+			{"from": "STATE1", "to": "STATE2", "event": "EVENT1", "guard": "isRefunded"},
+			{"from": "STATE9", "to": "STATE2", "event": "EVENT9", "action": "refund"},
+			{"from": "STATE2", "to": "STATE3", "event": "EVENT2", "type": "GLOBAL", "after": "soon"},
+			{"from": "STATE2", "event": "EVENT3", "type": "internal"},
+			{"from": "STATE3", "to": "STATE2", "event": "EVENT3", "type": "LOCAL"}
+		]
+	}`), newTestFunctionRegistry(&records))
+	var definitionError *DefinitionError
+	if !errors.As(err, &definitionError) || !errors.Is(err, ErrInvalidDefinition) {
+		t.Fatalf("LoadJSON() err = %v", err)
+	}
+	var got []string
+	for _, problem := range definitionError.Problems {
+		got = append(got, problem.Error())
+	}
+	want := []string{
+		`transitions[0].guard: unknown guard "isRefunded"`,
+		`transitions[1].from: can not parse "STATE9" as state: unknown name "STATE9"`,
+		`transitions[1].event: can not parse "EVENT9" as event: unknown name "EVENT9"`,
+		`transitions[1].action: unknown action "refund"`,
+		`transitions[2].type: unknown transition type "GLOBAL"`,
+		`transitions[2].after: invalid duration "soon"`,
+		`transitions[3].type: unknown transition type "internal"`,
+		`transitions[3].to: target state is required`,
+		`transitions[4].type: unknown transition type "LOCAL"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problems = %q, want %q", got, want)
+	}
+	// 有问题时不会注册任何流转
+	if len(builder.stateMachine.stateMap) != 0 {
+		t.Errorf("states registered: %v", builder.stateMachine.stateMap)
+	}
+
+	// 默认按字面值解析状态和事件
+	builder = NewBuilder[States, Events, *Entity]()
+	err = builder.LoadJSON([]byte(`{"transitions": [{"from": "1", "to": "STATE2", "event": "1"}]}`), nil)
+	if err == nil || err.Error() != "invalid state machine definition with 1 problems:\n"+
+		`  - transitions[0].to: can not parse "STATE2" as state: strconv.ParseInt: parsing "STATE2": invalid syntax` {
+		t.Errorf("LoadJSON() err = %v", err)
+	}
+	if err = builder.LoadJSON([]byte(`{"transitions": [], "unknown": 1}`), nil); !errors.Is(err, ErrInvalidDefinition) {
+		t.Errorf("LoadJSON() err = %v, want %v", err, ErrInvalidDefinition)
+	}
+}
+
+func Test_loadYAML(t *testing.T) {
+	var records []string
+	builder := NewBuilder[States, Events, *Entity]()
+	err := builder.LoadYAML([]byte(`
+initial: STATE1
+transitions:
+  - from: STATE1
+    to: STATE2
+    event: EVENT1
+    guard: isPaid
+  # 支付前和支付后都可以取消
+  - from: [STATE1, STATE2]
+    to: STATE4
+    event: EVENT4
+    action: notify
+  - from:
+      - STATE2
+      - STATE3
+    event: EVENT3
+    type: INTERNAL
+    action: notify
+`), newTestFunctionRegistry(&records))
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine, err := builder.BuildUnregistered("TestStateMachine-loadYAML")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		from, to States
+		event    Events
+	}{{STATE1, STATE2, EVENT1}, {STATE1, STATE4, EVENT4}, {STATE2, STATE4, EVENT4}, {STATE3, STATE3, EVENT3}} {
+		if target, err := machine.FireEvent(step.from, step.event, &Entity{Status: step.from}); err != nil || target != step.to {
+			t.Errorf("FireEvent(%v, %v) = %v, %v, want %v", step.from, step.event, target, err, step.to)
+		}
+	}
+	if want := []string{"STATE1->STATE4", "STATE2->STATE4", "STATE3->STATE3"}; !reflect.DeepEqual(records, want) {
+		t.Errorf("actions = %v, want %v", records, want)
+	}
+}
+
+func Test_loadYAMLError(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     []string
+	}{
+		{
+			name: "schema",
+			document: `
+initial: [STATE1]
+transitions:
+  - from: []
+    to: STATE2
+    event: EVENT1
+    type: GLOBAL
+  - from: STATE2
+    to: STATE3
+    on: EVENT2
+`,
+			want: []string{
+				"line 2: initial: expected string, got array",
+				"line 4: transitions[0].from: expected at least 1 items, got 0",
+				`line 7: transitions[0].type: expected one of EXTERNAL, INTERNAL, got "GLOBAL"`,
+				"line 10: transitions[1].on: unknown field",
+				"line 8: transitions[1].event: is required",
+			},
+		},
+		{
+			name: "functions",
+			document: `
+transitions:
+  - from: STATE1
+    to: STATE2
+    event: EVENT1
+    guard: isRefunded
+  - from: [STATE2, STATE9]
+    to: STATE3
+    event: EVENT2
+    action: refund
+`,
+			want: []string{
+				`line 6: transitions[0].guard: unknown guard "isRefunded"`,
+				`line 7: transitions[1].from[1]: can not parse "STATE9" as state: unknown name "STATE9"`,
+				`line 10: transitions[1].action: unknown action "refund"`,
+			},
+		},
+		{
+			name:     "empty",
+			document: "",
+			want:     []string{"line 1: transitions: is required"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []string
+			builder := NewBuilder[States, Events, *Entity]()
+			err := builder.LoadYAML([]byte(tt.document), newTestFunctionRegistry(&records))
+			var definitionError *DefinitionError
+			if !errors.As(err, &definitionError) || !errors.Is(err, ErrInvalidDefinition) {
+				t.Fatalf("LoadYAML() err = %v", err)
+			}
+			var got []string
+			for _, problem := range definitionError.Problems {
+				got = append(got, problem.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems = %q, want %q", got, tt.want)
+			}
+			if len(builder.stateMachine.stateMap) != 0 {
+				t.Errorf("states registered: %v", builder.stateMachine.stateMap)
+			}
+		})
+	}
+	builder := NewBuilder[States, Events, *Entity]()
+	if err := builder.LoadYAML([]byte("transitions: [\n"), nil); !errors.Is(err, ErrInvalidDefinition) {
+		t.Errorf("LoadYAML() err = %v, want %v", err, ErrInvalidDefinition)
+	}
+}
+
+func Test_export(t *testing.T) {
+	var records []string
+	functions := newTestFunctionRegistry(&records)
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.InitialState(STATE1)
+	builder.FinalStates(STATE3)
+	// 运行时的钩子不属于结构，导出时忽略
+	builder.AddListener(NopListener[States, Events, *Entity]{})
+	builder.SetFailCallback(func(States, Events, *Entity) {})
+	builder.SetStateAccessor(func(c *Entity) States { return c.Status }, func(c *Entity, s States) { c.Status = s })
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		WhenNamed("isPaid", func(c *Entity) bool { return c.Status == STATE1 }).Perform(nil)
+	builder.ExternalTransition().From(STATE1, STATE2).To(STATE4).After(30*time.Minute, EVENT4).When(nil).
+		PerformNamed("notify", func(from States, to States, event Events, c *Entity) error { return nil })
+	builder.InternalTransition().Within(STATE2).On(EVENT3).When(nil).Perform(nil)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(nil).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition, err := machine.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 状态和事件按字面值导出，不使用 String 方法
+	want := &Definition{
+		States:  []string{"1", "2", "3", "4"},
+		Initial: "1",
+		Finals:  []string{"3"},
+		Transitions: []TransitionDefinition{
+			{From: Sources{"1"}, To: "2", Event: "1", Type: "EXTERNAL", Guard: "isPaid"},
+			{From: Sources{"1"}, To: "4", Event: "4", Type: "EXTERNAL", After: "30m0s", Action: "notify"},
+			{From: Sources{"2"}, To: "3", Event: "2", Type: "EXTERNAL"},
+			{From: Sources{"2"}, Event: "3", Type: "INTERNAL"},
+			{From: Sources{"2"}, To: "4", Event: "4", Type: "EXTERNAL", After: "30m0s", Action: "notify"},
+		},
+	}
+	if !reflect.DeepEqual(definition, want) {
+		t.Errorf("Export() = %+v, want %+v", definition, want)
+	}
+
+	jsonData, err := json.Marshal(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yamlData, err := yaml.Marshal(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 默认的 FunctionRegistry 按字面值解析状态和事件
+	literal := NewFunctionRegistry[States, Events, *Entity]()
+	literal.RegisterGuard("isPaid", func(c *Entity) bool { return c.Status == STATE1 })
+	literal.RegisterAction("notify", func(from States, to States, event Events, c *Entity) error { return nil })
+	loads := map[string]func(b *Builder[States, Events, *Entity]) error{
+		"json":    func(b *Builder[States, Events, *Entity]) error { return b.LoadJSON(jsonData, functions) },
+		"yaml":    func(b *Builder[States, Events, *Entity]) error { return b.LoadYAML(yamlData, functions) },
+		"literal": func(b *Builder[States, Events, *Entity]) error { return b.LoadYAML(yamlData, literal) },
+	}
+	for name, load := range loads {
+		t.Run(name, func(t *testing.T) {
+			builder := NewBuilder[States, Events, *Entity]()
+			if err := load(builder); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := builder.BuildUnregistered("TestStateMachine-export")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if definition, err := loaded.Export(); err != nil || !reflect.DeepEqual(definition, want) {
+				t.Errorf("Export() = %+v, %v, want %+v", definition, err, want)
+			}
+			for _, state := range []States{STATE1, STATE2, STATE3, STATE4} {
+				for _, event := range []Events{EVENT1, EVENT2, EVENT3, EVENT4} {
+					for _, status := range []States{STATE1, STATE2} {
+						got, gotErr := loaded.FireEvent(state, event, &Entity{Status: status})
+						expected, expectedErr := machine.FireEvent(state, event, &Entity{Status: status})
+						if got != expected || (gotErr == nil) != (expectedErr == nil) {
+							t.Errorf("FireEvent(%v, %v) = %v, %v, want %v, %v", state, event, got, gotErr, expected, expectedErr)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func Test_exportAfter(t *testing.T) {
+	var records []string
+	functions := newTestFunctionRegistry(&records)
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.ExternalTransition().From(STATE1).To(STATE4).After(time.Minute, EVENT4).When(nil).Perform(nil)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT4).
+		WhenNamed("isPaid", func(c *Entity) bool { return c.Status == STATE1 }).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-exportAfter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition, err := machine.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只有通过 After 声明的流转导出超时时间
+	want := []TransitionDefinition{
+		{From: Sources{"1"}, To: "4", Event: "4", Type: "EXTERNAL", After: "1m0s"},
+		{From: Sources{"1"}, To: "2", Event: "4", Type: "EXTERNAL", Guard: "isPaid"},
+	}
+	if !reflect.DeepEqual(definition.Transitions, want) {
+		t.Errorf("Export() = %+v, want %+v", definition.Transitions, want)
+	}
+
+	builder = NewBuilder[States, Events, *Entity]()
+	builder.SetScheduler(NewManualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	if err = builder.LoadDefinition(definition, functions); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := builder.BuildUnregistered("TestStateMachine-exportAfter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := loaded.NewInstance(STATE1, &Entity{Status: STATE1})
+	defer instance.Stop()
+	if len(instance.timers) != 1 {
+		t.Errorf("timers = %d, want 1", len(instance.timers))
+	}
+}
+
+func Test_exportError(t *testing.T) {
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(func(c *Entity) bool { return true }).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-exportError")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = machine.Export(); !errors.Is(err, ErrNotExportable) {
+		t.Errorf("Export() err = %v, want %v", err, ErrNotExportable)
+	}
+
+	builder = NewBuilder[States, Events, *Entity]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(nil).Perform(nil)
+	machine, err = builder.BuildUnregistered("TestStateMachine-exportError")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = machine.Export(); !errors.Is(err, ErrNotExportable) {
+		t.Errorf("Export() err = %v, want %v", err, ErrNotExportable)
+	}
+
+	settings := map[string]func(b *Builder[States, Events, *Entity]){
+		"strict": func(b *Builder[States, Events, *Entity]) { b.SetStrict(true) },
+		"interceptors": func(b *Builder[States, Events, *Entity]) {
+			b.Use(func(next TransitHandler[States, Events, *Entity]) TransitHandler[States, Events, *Entity] {
+				return next
+			})
+		},
+		"maxChainDepth": func(b *Builder[States, Events, *Entity]) { b.SetMaxChainDepth(3) },
+	}
+	for name, set := range settings {
+		t.Run(name, func(t *testing.T) {
+			builder := NewBuilder[States, Events, *Entity]()
+			builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(nil)
+			set(builder)
+			machine, err := builder.BuildUnregistered("TestStateMachine-exportError")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = machine.Export(); !errors.Is(err, ErrNotExportable) {
+				t.Errorf("Export() err = %v, want %v", err, ErrNotExportable)
+			}
+		})
+	}
+}
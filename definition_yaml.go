@@ -0,0 +1,181 @@
+package statemachine
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefinitionSchema 状态机定义的 JSON Schema，LoadYAML 在注册流转之前按它校验文档
+//
+//go:embed definition.schema.json
+var DefinitionSchema string
+
+var definitionSchema = mustParseSchema(DefinitionSchema)
+
+// schema DefinitionSchema 中使用到的 JSON Schema 关键字
+type schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Properties           map[string]*schema `json:"properties"`
+	Items                *schema            `json:"items"`
+	MinItems             int                `json:"minItems"`
+	Enum                 []string           `json:"enum"`
+}
+
+// schemaTypes 允许的类型，schema 中可以写成字符串或者字符串数组
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var ty string
+	if err := json.Unmarshal(data, &ty); err == nil {
+		*t = schemaTypes{ty}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+func mustParseSchema(data string) *schema {
+	s := &schema{}
+	if err := json.Unmarshal([]byte(data), s); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s Sources) MarshalYAML() (any, error) {
+	if len(s) == 1 {
+		return s[0], nil
+	}
+	return []string(s), nil
+}
+
+// UnmarshalYAML 源状态可以写成字符串或者字符串列表
+func (s *Sources) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = Sources{value.Value}
+		return nil
+	}
+	return value.Decode((*[]string)(s))
+}
+
+// LoadYAML 从 YAML 文档加载状态机定义，文档先按 DefinitionSchema 校验，问题中包含出错的行号，见 LoadDefinition
+func (b *Builder[S, E, C]) LoadYAML(data []byte, functions *FunctionRegistry[S, E, C]) error {
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(data, document); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDefinition, err)
+	}
+	if len(document.Content) == 0 {
+		return &DefinitionError{Problems: []*DefinitionProblem{{Path: "transitions", Line: 1, Msg: "is required"}}}
+	}
+	root := document.Content[0]
+	validator := &schemaValidator{lines: make(map[string]int)}
+	validator.validate(definitionSchema, root, "")
+	if len(validator.problems) != 0 {
+		return &DefinitionError{Problems: validator.problems}
+	}
+	definition := &Definition{}
+	if err := root.Decode(definition); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDefinition, err)
+	}
+	return b.loadDefinition(definition, functions, validator.lines)
+}
+
+// schemaValidator 按 schema 校验 YAML 文档，同时记录每个字段路径对应的行号
+type schemaValidator struct {
+	lines    map[string]int
+	problems []*DefinitionProblem
+}
+
+func (v *schemaValidator) addProblem(path string, line int, format string, args ...any) {
+	if path == "" {
+		path = "(root)"
+	}
+	v.problems = append(v.problems, &DefinitionProblem{Path: path, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *schemaValidator) validate(s *schema, node *yaml.Node, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	v.lines[path] = node.Line
+	ty := nodeType(node)
+	if len(s.Type) != 0 && !contains(s.Type, ty) {
+		v.addProblem(path, node.Line, "expected %s, got %s", strings.Join(s.Type, " or "), ty)
+		return
+	}
+	switch ty {
+	case "object":
+		v.validateObject(s, node, path)
+	case "array":
+		if len(node.Content) < s.MinItems {
+			v.addProblem(path, node.Line, "expected at least %d items, got %d", s.MinItems, len(node.Content))
+		}
+		if s.Items != nil {
+			for k, item := range node.Content {
+				v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, k))
+			}
+		}
+	case "string":
+		if len(s.Enum) != 0 && !contains(s.Enum, node.Value) {
+			v.addProblem(path, node.Line, "expected one of %s, got %q", strings.Join(s.Enum, ", "), node.Value)
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(s *schema, node *yaml.Node, path string) {
+	keys := make(map[string]bool)
+	for k := 0; k+1 < len(node.Content); k += 2 {
+		key, value := node.Content[k], node.Content[k+1]
+		keys[key.Value] = true
+		property, ok := s.Properties[key.Value]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				v.addProblem(joinPath(path, key.Value), key.Line, "unknown field")
+			}
+			continue
+		}
+		v.validate(property, value, joinPath(path, key.Value))
+	}
+	for _, name := range s.Required {
+		if !keys[name] {
+			v.addProblem(joinPath(path, name), node.Line, "is required")
+		}
+	}
+}
+
+// nodeType 返回节点对应的 JSON Schema 类型，除了 null 以外的标量都可以作为字符串
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "null"
+		}
+		return "string"
+	}
+	return "unknown"
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package statemachine
+
+// initialStates 返回声明的起始状态，没有声明时推断起始状态：没有从其他状态流入，但是有流出的顶层状态，
+// 子状态通过复合状态进入，不会作为状态机的起始状态
+func (s *stateMachine[S, E, C]) initialStates() map[S]bool {
+	if s.initial != nil {
+		return map[S]bool{s.initial.id: true}
+	}
+	incoming := s.incomingStates()
+	res := make(map[S]bool)
+	for stateId, state := range s.stateMap {
+		if state.parent == nil && !incoming[stateId] && len(state.getAllEventTransitions()) != 0 {
+			res[stateId] = true
+		}
+	}
+	return res
+}
+
+// terminalStates 返回声明的终止状态，没有声明时推断终止状态：不是复合状态，有流入，但是自身和父状态上都没有流向其他状态的流转
+func (s *stateMachine[S, E, C]) terminalStates() map[S]bool {
+	if len(s.finals) != 0 {
+		return s.finals
+	}
+	incoming := s.incomingStates()
+	res := make(map[S]bool)
+	for stateId, state := range s.stateMap {
+		if len(state.children) == 0 && incoming[stateId] && !state.hasOutgoing() {
+			res[stateId] = true
+		}
+	}
+	return res
+}
+
+// incomingStates 返回有从其他状态流入的状态，流入子状态也意味着流入了不包含源状态的复合状态
+func (s *stateMachine[S, E, C]) incomingStates() map[S]bool {
+	res := make(map[S]bool)
+	for _, state := range s.stateMap {
+		for _, transition := range state.getAllEventTransitions() {
+			source := transition.source
+			for p := transition.target; p != nil && p != source && !source.isDescendantOf(p); p = p.parent {
+				res[p.id] = true
+			}
+		}
+	}
+	return res
+}
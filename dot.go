@@ -0,0 +1,95 @@
+package statemachine
+
+import (
+	"fmt"
+	"strings"
+)
+
+type dotOptions struct {
+	clusters bool
+}
+
+// DOTOption GenerateDOT 的选项
+type DOTOption func(o *dotOptions)
+
+// WithDOTClusters 把复合状态和它的子状态画在同一个 cluster 中
+func WithDOTClusters() DOTOption {
+	return func(o *dotOptions) {
+		o.clusters = true
+	}
+}
+
+func (s *stateMachine[S, E, C]) GenerateDOT(options ...DOTOption) string {
+	opts := &dotOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	initials, terminals := s.initialStates(), s.terminalStates()
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("digraph %s {\n", dotQuote(s.machineId)))
+	if opts.clusters {
+		builder.WriteString("  compound=true;\n")
+	}
+	builder.WriteString("  rankdir=LR;\n")
+	builder.WriteString("  node [shape=ellipse];\n")
+	writeNode := func(state *state[S, E, C], indent string) {
+		switch {
+		case initials[state.id]:
+			builder.WriteString(fmt.Sprintf("%s%s [shape=Mdiamond];\n", indent, dotQuote(state.id)))
+		case terminals[state.id]:
+			builder.WriteString(fmt.Sprintf("%s%s [shape=doublecircle];\n", indent, dotQuote(state.id)))
+		default:
+			builder.WriteString(fmt.Sprintf("%s%s;\n", indent, dotQuote(state.id)))
+		}
+	}
+	var writeCluster func(state *state[S, E, C], indent string)
+	writeCluster = func(state *state[S, E, C], indent string) {
+		builder.WriteString(fmt.Sprintf("%ssubgraph %s {\n", indent, dotQuote(fmt.Sprintf("cluster_%v", state.id))))
+		builder.WriteString(fmt.Sprintf("%s  label=%s;\n", indent, dotQuote(state.id)))
+		// 和复合状态同名的隐藏节点，作为复合状态上流转的端点
+		builder.WriteString(fmt.Sprintf("%s  %s [shape=point, style=invis];\n", indent, dotQuote(state.id)))
+		for _, child := range sortStates(state.children) {
+			if len(child.children) != 0 {
+				writeCluster(child, indent+"  ")
+			} else {
+				writeNode(child, indent+"  ")
+			}
+		}
+		builder.WriteString(indent + "}\n")
+	}
+	states := s.stateMap.sorted()
+	for _, state := range states {
+		switch {
+		case !opts.clusters || (state.parent == nil && len(state.children) == 0):
+			writeNode(state, "  ")
+		case state.parent == nil:
+			writeCluster(state, "  ")
+		}
+	}
+	for _, state := range states {
+		for _, transition := range state.getSortedEventTransitions() {
+			attrs := []string{"label=" + dotQuote(transition.label())}
+			switch transition.ty {
+			case INTERNAL:
+				attrs = append(attrs, "style=dashed")
+			case LOCAL:
+				attrs = append(attrs, "style=dotted")
+			}
+			source, target := transition.source, transition.target
+			// cluster 不能作为边的端点，边连到 cluster 中的隐藏节点，另一端在 cluster 之外时通过 ltail/lhead 裁剪到 cluster 的边界
+			if opts.clusters && len(source.children) != 0 && target != source && !target.isDescendantOf(source) {
+				attrs = append(attrs, "ltail="+dotQuote(fmt.Sprintf("cluster_%v", source.id)))
+			}
+			if opts.clusters && len(target.children) != 0 && source != target && !source.isDescendantOf(target) {
+				attrs = append(attrs, "lhead="+dotQuote(fmt.Sprintf("cluster_%v", target.id)))
+			}
+			builder.WriteString(fmt.Sprintf("  %s -> %s [%s];\n", dotQuote(source.id), dotQuote(target.id), strings.Join(attrs, ", ")))
+		}
+	}
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+func dotQuote(v any) string {
+	return `"` + strings.ReplaceAll(fmt.Sprintf("%v", v), `"`, `\"`) + `"`
+}
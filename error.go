@@ -1,11 +1,31 @@
 package statemachine
 
 import (
+	"context"
 	"errors"
+	"fmt"
 )
 
+var (
+	// ErrCanceled 流转执行前或执行过程中 context.Context 被取消，状态没有推进
+	ErrCanceled = NewError("transition canceled")
+	// ErrTransitionNotFound 状态上没有定义事件对应的流转，只在严格模式下返回
+	ErrTransitionNotFound = NewError("transition not found")
+	// ErrGuardRejected 事件对应的流转条件都不满足，只在严格模式下返回
+	ErrGuardRejected = NewError("transition rejected by guard")
+	// ErrFinalState 在终止状态上触发了事件
+	ErrFinalState = NewError("event fired from final state")
+	// ErrNoEventQueue 不是通过实例触发的动作投递了事件
+	ErrNoEventQueue = NewError("no event queue in context, events can only be posted by actions fired by an instance")
+	// ErrMaxChainDepth 投递的事件链超过了最大深度
+	ErrMaxChainDepth = NewError("event chain exceeds max depth")
+	// ErrActionFailed 流转动作、进入或离开动作返回了错误，只在严格模式下返回
+	ErrActionFailed = NewError("transition action failed")
+)
+
+// NewError 创建状态机错误，每次调用都返回不同的错误，errors.Is 按指针比较，消息相同的错误也不相等
 func NewError(msg string) error {
-	return Error{
+	return &Error{
 		msg: msg,
 	}
 }
@@ -14,11 +34,81 @@ type Error struct {
 	msg string
 }
 
-func (e Error) Error() string {
+func (e *Error) Error() string {
 	return e.msg
 }
 
 func IsStateMachineError(err error) bool {
-	e := &Error{}
-	return errors.As(err, e)
+	var e *Error
+	return errors.As(err, &e)
+}
+
+// TransitionNotFoundError 状态 State 上没有定义事件 Event 对应的流转
+type TransitionNotFoundError[S, E ID] struct {
+	MachineId string
+	State     S
+	Event     E
+}
+
+func (e *TransitionNotFoundError[S, E]) Error() string {
+	return fmt.Sprintf("state machine [%s]: no transition for event %v in state %v", e.MachineId, e.Event, e.State)
+}
+
+func (e *TransitionNotFoundError[S, E]) Unwrap() error {
+	return ErrTransitionNotFound
+}
+
+// GuardRejectedError 状态 State 上事件 Event 对应的流转条件都不满足
+type GuardRejectedError[S, E ID] struct {
+	MachineId string
+	State     S
+	Event     E
+}
+
+func (e *GuardRejectedError[S, E]) Error() string {
+	return fmt.Sprintf("state machine [%s]: event %v in state %v rejected by guard", e.MachineId, e.Event, e.State)
+}
+
+func (e *GuardRejectedError[S, E]) Unwrap() error {
+	return ErrGuardRejected
+}
+
+// FinalStateError 在终止状态 State 上触发了事件 Event
+type FinalStateError[S, E ID] struct {
+	MachineId string
+	State     S
+	Event     E
+}
+
+func (e *FinalStateError[S, E]) Error() string {
+	return fmt.Sprintf("state machine [%s]: event %v fired from final state %v", e.MachineId, e.Event, e.State)
+}
+
+func (e *FinalStateError[S, E]) Unwrap() error {
+	return ErrFinalState
+}
+
+// ActionFailedError 从 From 到 To 的流转过程中动作返回了错误 Err
+type ActionFailedError[S, E ID] struct {
+	MachineId string
+	From      S
+	To        S
+	Event     E
+	Err       error
+}
+
+func (e *ActionFailedError[S, E]) Error() string {
+	return fmt.Sprintf("state machine [%s]: action of %v-[%v]->%v failed: %v", e.MachineId, e.From, e.Event, e.To, e.Err)
+}
+
+func (e *ActionFailedError[S, E]) Unwrap() []error {
+	return []error{ErrActionFailed, e.Err}
+}
+
+// canceled ctx 被取消时返回同时包装 ErrCanceled 和 ctx.Err() 的错误
+func canceled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	}
+	return nil
 }
@@ -1,6 +1,9 @@
 package statemachine
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 func newEventTransitions[S, E ID, C any]() *eventTransitions[S, E, C] {
 	return &eventTransitions[S, E, C]{
@@ -39,6 +42,22 @@ func (e *eventTransitions[S, E, C]) all() []*Transition[S, E, C] {
 	return res
 }
 
+// sorted 按事件排序返回所有流转，同一个事件的流转保持声明的顺序
+func (e *eventTransitions[S, E, C]) sorted() []*Transition[S, E, C] {
+	events := make([]E, 0, len(e.eventTransitions))
+	for event := range e.eventTransitions {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i] < events[j]
+	})
+	res := make([]*Transition[S, E, C], 0, 8)
+	for _, event := range events {
+		res = append(res, e.eventTransitions[event]...)
+	}
+	return res
+}
+
 func (e *eventTransitions[S, E, C]) verify(existingTransitions []*Transition[S, E, C], transition *Transition[S, E, C]) error {
 	for _, v := range existingTransitions {
 		if v.equals(transition) {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/yzrzr/statemachine"
@@ -81,22 +82,38 @@ func main() {
 	order := &Order{
 		Status: None,
 	}
-	target, err := machine.FireEvent(None, CreateEvent, order)
+	// 从订单创建实例，触发事件成功后订单状态会通过状态访问器自动更新
+	instance, err := machine.NewEntityInstance(order)
+	if err != nil {
+		panic(err)
+	}
+	target, err := instance.Fire(CreateEvent, order)
 	fmt.Println(target, err)
-	target, err = machine.FireEvent(order.Status, PaymentEvent, order)
+	target, err = instance.Fire(PaymentEvent, order)
 	fmt.Println(target, err)
-	target, err = machine.FireEvent(order.Status, DeliverEvent, order)
+	target, err = instance.Fire(DeliverEvent, order)
 	fmt.Println(target, err)
-	target, err = machine.FireEvent(order.Status, ConfirmEvent, order)
+	target, err = instance.Fire(ConfirmEvent, order)
 	fmt.Println(target, err)
-	target, err = machine.FireEvent(order.Status, EvaluationEvent, order)
+	target, err = instance.Fire(EvaluationEvent, order)
 	fmt.Println(target, err)
 	// 状态转移失败，状态不变
-	target, err = machine.FireEvent(order.Status, EvaluationEvent, order)
-	fmt.Println(target, err)
+	target, err = instance.Fire(EvaluationEvent, order)
+	fmt.Println(target, err, order.Status)
 
 	uml := machine.GeneratePlantUML()
 	fmt.Println(uml)
+
+	// 导出声明式定义，监听器、失败回调和状态访问器不会导出
+	definition, err := machine.Export()
+	if err != nil {
+		panic(err)
+	}
+	data, err := json.MarshalIndent(definition, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(data))
 }
 
 func createOrderStateMachine() statemachine.StateMachine[OrderStatus, OrderEvent, *Order] {
@@ -104,66 +121,66 @@ func createOrderStateMachine() statemachine.StateMachine[OrderStatus, OrderEvent
 	builder.SetFailCallback(func(sourceState OrderStatus, event OrderEvent, ctx *Order) {
 		fmt.Println("状态转移失败")
 	})
+	// 读写订单状态
+	builder.SetStateAccessor(func(ctx *Order) OrderStatus {
+		return ctx.Status
+	}, func(ctx *Order, status OrderStatus) {
+		ctx.Status = status
+	})
 	// 创建订单，触发创建事件，状态转移到等待支付
 	builder.ExternalTransition().From(None).To(WaitPayment).On(CreateEvent).
-		When(func(ctx *Order) bool {
+		WhenNamed("isNone", func(ctx *Order) bool {
 			return ctx.Status == None
-		}).Perform(func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
+		}).PerformNamed("notifyCreated", func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
 		fmt.Println("订单创建成功，等待支付")
-		ctx.Status = to
 		return nil
 	})
 	// 商户改价，触发改价事件，状态不变
 	builder.InternalTransition().Within(WaitPayment).On(ChangePriceEvent).
-		When(func(ctx *Order) bool {
+		WhenNamed("isWaitPayment", func(ctx *Order) bool {
 			return ctx.Status == WaitPayment
-		}).Perform(func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
+		}).PerformNamed("notifyPriceChanged", func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
 		fmt.Println("商户改价成功，等待支付")
 		return nil
 	})
 	// 支付，触发支付事件，状态转移到等待发货
 	builder.ExternalTransition().From(WaitPayment).To(WaitDeliver).On(PaymentEvent).
-		When(func(ctx *Order) bool {
+		WhenNamed("isWaitPayment", func(ctx *Order) bool {
 			return ctx.Status == WaitPayment
-		}).Perform(func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
+		}).PerformNamed("notifyPaid", func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
 		fmt.Println("订单支付成功，等待发货")
-		ctx.Status = to
 		return nil
 	})
 	// 取消订单，触发取消事件，状态转移到交易关闭
 	builder.ExternalTransition().From(WaitPayment).To(CancelOrder).On(CancelEvent).
-		When(func(ctx *Order) bool {
+		WhenNamed("isWaitPayment", func(ctx *Order) bool {
 			return ctx.Status == WaitPayment
-		}).Perform(func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
+		}).PerformNamed("notifyCanceled", func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
 		fmt.Println("用户取消订单，交易关闭")
-		ctx.Status = to
 		return nil
 	})
 	// 发货，触发发货事件，状态转移到等待收货
 	builder.ExternalTransition().From(WaitDeliver).To(WaitConfirm).On(DeliverEvent).
-		When(func(ctx *Order) bool {
+		WhenNamed("isWaitDeliver", func(ctx *Order) bool {
 			return ctx.Status == WaitDeliver
-		}).Perform(func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
+		}).PerformNamed("notifyDelivered", func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
 		fmt.Println("订单发货成功，等待用户确认收货")
-		ctx.Status = to
 		return nil
 	})
 	// 用户确认发货，触发收货事件，状态转移到等待评价
 	builder.ExternalTransition().From(WaitConfirm).To(WaitEvaluation).On(ConfirmEvent).
-		When(func(ctx *Order) bool {
+		WhenNamed("isWaitConfirm", func(ctx *Order) bool {
 			return ctx.Status == WaitConfirm
-		}).Perform(func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
+		}).PerformNamed("notifyConfirmed", func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
 		fmt.Println("用户确认发货成功，等待用户评价")
-		ctx.Status = to
 		return nil
 	})
 	// 用户评价，触发评价事件，状态转移到交易完成
 	builder.ExternalTransition().From(WaitEvaluation).To(Complete).On(EvaluationEvent).
-		When(func(ctx *Order) bool {
+		WhenNamed("isWaitEvaluation", func(ctx *Order) bool {
 			return ctx.Status == WaitEvaluation
-		}).Perform(func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
+		}).PerformNamed("notifyCompleted", func(from OrderStatus, to OrderStatus, event OrderEvent, ctx *Order) error {
 		fmt.Println("用户评价成功，交易完成")
-		ctx.Status = to
 		return nil
 	})
 
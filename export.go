@@ -0,0 +1,89 @@
+package statemachine
+
+import (
+	"fmt"
+)
+
+// ErrNotExportable 状态机使用了声明式定义无法表示的功能，导出后重新加载的状态机行为会不一致
+var ErrNotExportable = NewError("state machine can not be exported")
+
+func (s *stateMachine[S, E, C]) Export() (*Definition, error) {
+	if err := s.exportableMachine(); err != nil {
+		return nil, err
+	}
+	definition := &Definition{Transitions: []TransitionDefinition{}}
+	states := s.stateMap.sorted()
+	for _, state := range states {
+		if err := s.exportable(state); err != nil {
+			return nil, err
+		}
+		definition.States = append(definition.States, formatID(state.id))
+		if s.finals[state.id] {
+			definition.Finals = append(definition.Finals, formatID(state.id))
+		}
+	}
+	if s.initial != nil {
+		definition.Initial = formatID(s.initial.id)
+	}
+	for _, state := range states {
+		for _, transition := range state.getSortedEventTransitions() {
+			if err := s.exportableTransition(transition); err != nil {
+				return nil, err
+			}
+			t := TransitionDefinition{
+				From:   Sources{formatID(transition.source.id)},
+				Event:  formatID(transition.event),
+				Type:   transition.ty.String(),
+				Guard:  transition.conditionName,
+				Action: transition.actionName,
+			}
+			if transition.ty != INTERNAL {
+				t.To = formatID(transition.target.id)
+			}
+			if transition.after > 0 {
+				t.After = transition.after.String()
+			}
+			definition.Transitions = append(definition.Transitions, t)
+		}
+	}
+	return definition, nil
+}
+
+// exportableMachine 校验状态机级别的设置，监听器、失败回调、状态访问器和 Scheduler 是运行时的钩子，不属于状态机的结构，导出时忽略
+func (s *stateMachine[S, E, C]) exportableMachine() error {
+	switch {
+	case s.strict:
+		return fmt.Errorf("%w: strict mode", ErrNotExportable)
+	case len(s.interceptors) != 0:
+		return fmt.Errorf("%w: interceptors", ErrNotExportable)
+	case s.maxChainDepth != defaultMaxChainDepth:
+		return fmt.Errorf("%w: max chain depth %d", ErrNotExportable, s.maxChainDepth)
+	}
+	return nil
+}
+
+// exportable 校验状态是否只使用了声明式定义可以表示的功能
+func (s *stateMachine[S, E, C]) exportable(state *state[S, E, C]) error {
+	switch {
+	case state.parent != nil || len(state.children) != 0:
+		return fmt.Errorf("%w: composite state %v", ErrNotExportable, state.id)
+	case len(state.deferred) != 0:
+		return fmt.Errorf("%w: deferred events in state %v", ErrNotExportable, state.id)
+	case state.entryAction != nil || state.exitAction != nil:
+		return fmt.Errorf("%w: entry or exit action in state %v", ErrNotExportable, state.id)
+	}
+	return nil
+}
+
+// exportableTransition 条件和动作必须有名字才能在加载时通过 FunctionRegistry 找到
+func (s *stateMachine[S, E, C]) exportableTransition(transition *Transition[S, E, C]) error {
+	switch {
+	case transition.condition != nil && transition.conditionName == "":
+		return fmt.Errorf("%w: transition %s has an unnamed guard", ErrNotExportable, transition)
+	case transition.action != nil && transition.actionName == "":
+		return fmt.Errorf("%w: transition %s has an unnamed action", ErrNotExportable, transition)
+	case len(transition.interceptors) != 0:
+		return fmt.Errorf("%w: transition %s has interceptors", ErrNotExportable, transition)
+	}
+	return nil
+}
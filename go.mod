@@ -1,3 +1,8 @@
 module github.com/yzrzr/statemachine
 
 go 1.20
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.33
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,175 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Instance 保存了当前状态的状态机实例，触发事件时不需要再传入当前状态
+// 同一个实例上的事件串行执行，不同实例之间可以并行
+type Instance[S, E ID, C any] struct {
+	mu      sync.Mutex
+	machine *stateMachine[S, E, C]
+	current S
+	// deferred 等待状态改变后重新处理的延迟事件
+	deferred []deferredEvent[E, C]
+	// ctx 创建实例或者最近一次触发事件使用的上下文，超时事件会使用它
+	ctx C
+	// timers 当前状态和父状态上等待触发的超时事件
+	timers []*pendingTimer[S, E]
+	// journal 记录实例处理过的事件，encode 用来序列化上下文
+	journal Journal[S, E]
+	encode  func(c C) ([]byte, error)
+}
+
+// deferredEvent 延迟处理的事件和触发它时的上下文，重新处理时使用自己的上下文
+type deferredEvent[E ID, C any] struct {
+	event E
+	ctx   C
+}
+
+func newInstance[S, E ID, C any](machine *stateMachine[S, E, C], stateId S, c C) *Instance[S, E, C] {
+	i := &Instance[S, E, C]{
+		machine: machine,
+		current: stateId,
+		ctx:     c,
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.scheduleTimers()
+	return i
+}
+
+// Machine 获取实例所属的状态机
+func (i *Instance[S, E, C]) Machine() StateMachine[S, E, C] {
+	return i.machine
+}
+
+// Current 获取当前状态
+func (i *Instance[S, E, C]) Current() S {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.current
+}
+
+// Deferred 获取等待处理的延迟事件
+func (i *Instance[S, E, C]) Deferred() []E {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.deferredEvents()
+}
+
+func (i *Instance[S, E, C]) deferredEvents() []E {
+	var res []E
+	for _, d := range i.deferred {
+		res = append(res, d.event)
+	}
+	return res
+}
+
+// Can 验证当前状态是否可以触发事件 E
+func (i *Instance[S, E, C]) Can(event E) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.machine.Verify(i.current, event)
+}
+
+// Fire 在当前状态触发事件 E，成功后更新当前状态，设置了状态访问器时同时把状态写回 ctx
+func (i *Instance[S, E, C]) Fire(event E, ctx C) (S, error) {
+	return i.FireContext(context.Background(), event, ctx)
+}
+
+// FireContext 同 Fire，ctx 被取消时返回 ErrCanceled 并且状态不变
+// 动作在持有实例锁的情况下执行，不能在动作中再调用同一个实例的方法，需要触发后续事件时使用 PerformPost 设置的动作投递
+// 当前流转完成后按投递的顺序处理投递的事件，返回最后的状态和第一个错误
+func (i *Instance[S, E, C]) FireContext(ctx context.Context, event E, c C) (S, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.fireLocked(ctx, event, c)
+}
+
+func (i *Instance[S, E, C]) fireLocked(ctx context.Context, event E, c C) (S, error) {
+	i.ctx = c
+	queue := &eventQueue[E]{}
+	ctx = context.WithValue(ctx, eventQueueKey{}, queue)
+	if err := i.fire(ctx, event, c); err != nil {
+		return i.current, err
+	}
+	// 每一轮处理上一轮投递的事件，轮数就是事件链的深度
+	for depth := 1; len(queue.events) != 0; depth++ {
+		if depth > i.machine.maxChainDepth {
+			return i.current, fmt.Errorf("%w: %d", ErrMaxChainDepth, i.machine.maxChainDepth)
+		}
+		events := queue.events
+		queue.events = nil
+		for _, e := range events {
+			if err := i.fire(ctx, e, c); err != nil {
+				return i.current, err
+			}
+		}
+	}
+	return i.current, nil
+}
+
+func (i *Instance[S, E, C]) fire(ctx context.Context, event E, c C) error {
+	// 延迟事件不会触发 FailCallback，等状态改变后再处理
+	if i.machine.shouldDefer(i.current, event) {
+		i.deferred = append(i.deferred, deferredEvent[E, C]{event: event, ctx: c})
+		return i.record(ctx, event, i.current, i.current, OutcomeDeferred, c, nil)
+	}
+	from := i.current
+	to, transition, err := i.machine.fireEvent(ctx, from, event, c)
+	if err != nil {
+		if e := i.record(ctx, event, from, from, OutcomeFailed, c, err); e != nil {
+			return errors.Join(err, e)
+		}
+		return err
+	}
+	i.current = to
+	if i.machine.stateSetter != nil {
+		i.machine.stateSetter(c, to)
+	}
+	i.transitTimers(from, to, transition)
+	// 状态已经改变，记录失败时仍然返回错误，延迟事件留到下一次状态改变后处理
+	if err = i.record(ctx, event, from, to, OutcomeTransitioned, c, nil); err != nil {
+		return err
+	}
+	if to == from || len(i.deferred) == 0 {
+		return nil
+	}
+	// 状态改变后按到达的顺序使用各自的上下文重新处理延迟事件，仍然需要延迟的事件会重新进入队列
+	// 处理失败的延迟事件会被丢弃，错误通过 DeferredFailCallback 和日志报告，不影响触发状态改变的事件
+	deferred := i.deferred
+	i.deferred = nil
+	for _, d := range deferred {
+		if err = i.fire(ctx, d.event, d.ctx); err != nil && i.machine.deferredFailCallback != nil {
+			i.machine.deferredFailCallback(i.current, d.event, d.ctx, err)
+		}
+	}
+	return nil
+}
+
+type eventQueueKey struct{}
+
+// eventQueue 实例在一次 Fire 过程中投递的事件
+type eventQueue[E ID] struct {
+	events []E
+}
+
+func (q *eventQueue[E]) post(event E) error {
+	q.events = append(q.events, event)
+	return nil
+}
+
+// posterOf 返回投递到实例事件队列的 Poster，不是通过实例触发时 Poster 返回 ErrNoEventQueue
+func posterOf[E ID](ctx context.Context) Poster[E] {
+	queue, ok := ctx.Value(eventQueueKey{}).(*eventQueue[E])
+	if !ok {
+		return func(event E) error {
+			return ErrNoEventQueue
+		}
+	}
+	return queue.post
+}
@@ -0,0 +1,388 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Entity struct {
+	Status States
+}
+
+func buildEntityStateMachine(machineId string) StateMachine[States, Events, *Entity] {
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetStateAccessor(func(c *Entity) States {
+		return c.Status
+	}, func(c *Entity, stateId States) {
+		c.Status = stateId
+	})
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(func(c *Entity) bool { return true }).Perform(nil)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).
+		When(func(c *Entity) bool { return true }).Perform(nil)
+	builder.ExternalTransition().From(STATE3).To(STATE1).On(EVENT3).
+		When(func(c *Entity) bool { return true }).Perform(nil)
+	machine, err := builder.BuildUnregistered(machineId)
+	if err != nil {
+		panic(err)
+	}
+	return machine
+}
+
+func Test_instance(t *testing.T) {
+	machine := buildEntityStateMachine("TestStateMachine-instance")
+	instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	if instance.Current() != STATE1 {
+		t.Errorf("Current() = %v, want %v", instance.Current(), STATE1)
+	}
+	if !instance.Can(EVENT1) || instance.Can(EVENT2) {
+		t.Error("Can() returns wrong result")
+	}
+	entity := &Entity{Status: STATE1}
+	target, err := instance.Fire(EVENT1, entity)
+	if err != nil {
+		t.Error(err)
+	}
+	if target != STATE2 || instance.Current() != STATE2 || entity.Status != STATE2 {
+		t.Errorf("Fire() = %v, Current() = %v, entity = %v, want %v", target, instance.Current(), entity.Status, STATE2)
+	}
+	// 没有可执行的流转，状态不变
+	target, err = instance.Fire(EVENT1, entity)
+	if err != nil {
+		t.Error(err)
+	}
+	if target != STATE2 || instance.Current() != STATE2 {
+		t.Errorf("Fire() = %v, want %v", target, STATE2)
+	}
+}
+
+func Test_entityInstance(t *testing.T) {
+	machine := buildEntityStateMachine("TestStateMachine-entityInstance")
+	entity := &Entity{Status: STATE2}
+	instance, err := machine.NewEntityInstance(entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.Current() != STATE2 {
+		t.Errorf("Current() = %v, want %v", instance.Current(), STATE2)
+	}
+	if _, err = instance.Fire(EVENT2, entity); err != nil {
+		t.Error(err)
+	}
+	if entity.Status != STATE3 {
+		t.Errorf("entity.Status = %v, want %v", entity.Status, STATE3)
+	}
+
+	builder := NewBuilder[States, Events, *Entity]()
+	plain, err := builder.BuildUnregistered("TestStateMachine-entityInstanceWithoutAccessor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = plain.NewEntityInstance(entity); !IsStateMachineError(err) {
+		t.Errorf("NewEntityInstance() err = %v, want StateMachineError", err)
+	}
+}
+
+func Test_instanceGoroutine(t *testing.T) {
+	builder := NewBuilder[States, Events, *Entity]()
+	// observed 记录每个实例的动作看到的状态，事件交错执行时 from 会和 observed 不一致
+	observed := make([]States, 10)
+	action := func(from States, to States, event Events, c *Entity) error {
+		if observed[c.Status] != from {
+			t.Errorf("action from = %v, want %v", from, observed[c.Status])
+		}
+		observed[c.Status] = to
+		return nil
+	}
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(action)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(nil).Perform(action)
+	builder.ExternalTransition().From(STATE3).To(STATE1).On(EVENT3).When(nil).Perform(action)
+	machine, err := builder.BuildUnregistered("TestStateMachine-instanceGoroutine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := sync.WaitGroup{}
+	for n := range observed {
+		observed[n] = STATE1
+		instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+		// 用 Status 区分实例
+		entity := &Entity{Status: States(n)}
+		for g := 0; g < 10; g++ {
+			group.Add(1)
+			go func() {
+				defer group.Done()
+				for k := 0; k < 30; k++ {
+					if _, err := instance.Fire(Events(EVENT1+k%3), entity); err != nil {
+						t.Error(err)
+					}
+					instance.Current()
+					instance.Can(EVENT1)
+				}
+			}()
+		}
+	}
+	group.Wait()
+}
+
+func Test_instancePost(t *testing.T) {
+	var records []string
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).
+		PerformPost(func(ctx context.Context, post Poster[Events], from States, to States, event Events, c *Entity) error {
+			if err := post(EVENT2); err != nil {
+				return err
+			}
+			records = append(records, "pay")
+			return nil
+		})
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(nil).
+		PerformContext(func(ctx context.Context, from States, to States, event Events, c *Entity) error {
+			records = append(records, "deliver")
+			return nil
+		})
+	machine, err := builder.BuildUnregistered("TestStateMachine-instancePost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	target, err := instance.Fire(EVENT1, &Entity{})
+	if err != nil {
+		t.Error(err)
+	}
+	if target != STATE3 || instance.Current() != STATE3 {
+		t.Errorf("Fire() = %v, want %v", target, STATE3)
+	}
+	if want := []string{"pay", "deliver"}; !reflect.DeepEqual(records, want) {
+		t.Errorf("actions = %v, want %v", records, want)
+	}
+	// 没有通过实例触发，不能投递事件
+	if _, err = machine.FireEvent(STATE1, EVENT1, &Entity{}); !errors.Is(err, ErrNoEventQueue) {
+		t.Errorf("FireEvent err = %v, want %v", err, ErrNoEventQueue)
+	}
+}
+
+func Test_instanceMaxChainDepth(t *testing.T) {
+	pingPong := func(next Events) PostAction[States, Events, *Entity] {
+		return func(ctx context.Context, post Poster[Events], from States, to States, event Events, c *Entity) error {
+			return post(next)
+		}
+	}
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetMaxChainDepth(3)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).PerformPost(pingPong(EVENT2))
+	builder.ExternalTransition().From(STATE2).To(STATE1).On(EVENT2).When(nil).PerformPost(pingPong(EVENT1))
+	machine, err := builder.BuildUnregistered("TestStateMachine-instanceMaxChainDepth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	if _, err = instance.Fire(EVENT1, &Entity{}); !errors.Is(err, ErrMaxChainDepth) {
+		t.Errorf("Fire() err = %v, want %v", err, ErrMaxChainDepth)
+	}
+}
+
+func Test_instanceDefer(t *testing.T) {
+	var records []string
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetFailCallback(func(sourceState States, event Events, c *Entity) {
+		records = append(records, fmt.Sprintf("fail %v %v", sourceState, event))
+	})
+	builder.Defer(STATE2, EVENT4)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(nil)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(nil).
+		Perform(func(from States, to States, event Events, c *Entity) error {
+			records = append(records, "pay")
+			return nil
+		})
+	builder.InternalTransition().Within(STATE3).On(EVENT4).When(nil).
+		Perform(func(from States, to States, event Events, c *Entity) error {
+			records = append(records, fmt.Sprintf("change price %v", c.Status))
+			return nil
+		})
+	machine, err := builder.BuildUnregistered("TestStateMachine-instanceDefer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	entity := &Entity{}
+	if _, err = instance.Fire(EVENT1, entity); err != nil {
+		t.Error(err)
+	}
+	// 延迟事件重新处理时使用触发它时的上下文，而不是触发状态改变的上下文
+	if _, err = instance.Fire(EVENT4, &Entity{Status: STATE4}); err != nil {
+		t.Error(err)
+	}
+	if deferred := instance.Deferred(); !reflect.DeepEqual(deferred, []Events{EVENT4}) {
+		t.Errorf("Deferred() = %v, want [%v]", deferred, Events(EVENT4))
+	}
+	if _, err = instance.Fire(EVENT2, entity); err != nil {
+		t.Error(err)
+	}
+	if want := []string{"pay", "change price STATE4"}; !reflect.DeepEqual(records, want) {
+		t.Errorf("actions = %v, want %v", records, want)
+	}
+	if deferred := instance.Deferred(); len(deferred) != 0 {
+		t.Errorf("Deferred() = %v, want empty", deferred)
+	}
+	if instance.Current() != STATE3 {
+		t.Errorf("Current() = %v, want %v", instance.Current(), STATE3)
+	}
+}
+
+func Test_instanceDeferFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		final  bool
+		want   error
+	}{
+		{name: "final", final: true, want: ErrFinalState},
+		{name: "strict", strict: true, want: ErrTransitionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []string
+			builder := NewBuilder[States, Events, *Entity]()
+			builder.SetStrict(tt.strict)
+			if tt.final {
+				builder.FinalStates(STATE2)
+			}
+			builder.SetDeferredFailCallback(func(sourceState States, event Events, c *Entity, err error) {
+				if !errors.Is(err, tt.want) {
+					t.Errorf("err = %v, want %v", err, tt.want)
+				}
+				records = append(records, fmt.Sprintf("fail %v %v", sourceState, event))
+			})
+			builder.Defer(STATE1, EVENT4)
+			builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(nil)
+			machine, err := builder.BuildUnregistered("TestStateMachine-instanceDeferFailed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			journal := NewMemoryJournal[States, Events]()
+			instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+			instance.SetJournal(journal, nil)
+			if _, err = instance.Fire(EVENT4, &Entity{}); err != nil {
+				t.Error(err)
+			}
+			// 延迟事件失败不影响触发状态改变的事件，失败的延迟事件被丢弃
+			target, err := instance.Fire(EVENT1, &Entity{})
+			if err != nil || target != STATE2 {
+				t.Errorf("Fire() = %v, %v, want %v, nil", target, err, STATE2)
+			}
+			if deferred := instance.Deferred(); len(deferred) != 0 {
+				t.Errorf("Deferred() = %v, want empty", deferred)
+			}
+			if want := []string{"fail STATE2 EVENT4"}; !reflect.DeepEqual(records, want) {
+				t.Errorf("records = %v, want %v", records, want)
+			}
+			if entries := journal.Entries(); len(entries) != 3 || entries[2].Event != EVENT4 || entries[2].Outcome != OutcomeFailed {
+				t.Errorf("Entries() = %v", entries)
+			}
+		})
+	}
+}
+
+func Test_instanceTimeout(t *testing.T) {
+	scheduler := NewManualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetScheduler(scheduler)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(nil)
+	// 等待支付 30 分钟后自动取消
+	builder.ExternalTransition().From(STATE1).To(STATE4).After(30*time.Minute, EVENT4).When(nil).Perform(nil)
+	// 等待收货 7 天后自动确认
+	builder.ExternalTransition().From(STATE2).To(STATE3).After(7*24*time.Hour, EVENT3).When(nil).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-instanceTimeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paid := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	unpaid := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	scheduler.Advance(29 * time.Minute)
+	if paid.Current() != STATE1 || unpaid.Current() != STATE1 {
+		t.Errorf("Current() = %v, %v, want %v", paid.Current(), unpaid.Current(), STATE1)
+	}
+	if _, err = paid.Fire(EVENT1, &Entity{}); err != nil {
+		t.Error(err)
+	}
+	scheduler.Advance(time.Minute)
+	if paid.Current() != STATE2 {
+		t.Errorf("Current() = %v, want %v", paid.Current(), STATE2)
+	}
+	if unpaid.Current() != STATE4 {
+		t.Errorf("Current() = %v, want %v", unpaid.Current(), STATE4)
+	}
+	scheduler.Advance(7*24*time.Hour - time.Minute)
+	if paid.Current() != STATE3 {
+		t.Errorf("Current() = %v, want %v", paid.Current(), STATE3)
+	}
+}
+
+func Test_instanceTimeoutEntity(t *testing.T) {
+	scheduler := NewManualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetScheduler(scheduler)
+	builder.SetStateAccessor(func(c *Entity) States {
+		return c.Status
+	}, func(c *Entity, stateId States) {
+		c.Status = stateId
+	})
+	builder.ExternalTransition().From(STATE1).To(STATE4).After(time.Minute, EVENT4).
+		When(func(c *Entity) bool { return c.Status == STATE1 }).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-instanceTimeoutEntity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有触发过事件，超时事件使用创建实例时的上下文
+	entity := &Entity{Status: STATE1}
+	instance := machine.NewInstance(STATE1, entity)
+	scheduler.Advance(2 * time.Minute)
+	if instance.Current() != STATE4 || entity.Status != STATE4 {
+		t.Errorf("Current() = %v, entity = %v, want %v", instance.Current(), entity.Status, STATE4)
+	}
+}
+
+func Test_instanceTimeoutComposite(t *testing.T) {
+	scheduler := NewManualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetScheduler(scheduler)
+	builder.CompositeState(STATE1, STATE2, STATE3)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(nil).Perform(nil)
+	builder.ExternalTransition().From(STATE1).To(STATE4).After(10*time.Minute, EVENT4).When(nil).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-instanceTimeoutComposite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := machine.NewInstance(STATE2, &Entity{Status: STATE2})
+	scheduler.Advance(5 * time.Minute)
+	// 在复合状态内部流转不会离开复合状态，复合状态的超时继续计时
+	if _, err = instance.Fire(EVENT2, &Entity{Status: STATE2}); err != nil {
+		t.Fatal(err)
+	}
+	scheduler.Advance(5 * time.Minute)
+	if instance.Current() != STATE4 {
+		t.Errorf("Current() = %v, want %v", instance.Current(), STATE4)
+	}
+}
+
+func Test_instanceTimeoutStop(t *testing.T) {
+	scheduler := NewManualScheduler(time.Now())
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetScheduler(scheduler)
+	builder.ExternalTransition().From(STATE1).To(STATE4).After(time.Minute, EVENT4).When(nil).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-instanceTimeoutStop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	instance.Stop()
+	scheduler.Advance(time.Hour)
+	if instance.Current() != STATE1 {
+		t.Errorf("Current() = %v, want %v", instance.Current(), STATE1)
+	}
+}
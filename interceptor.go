@@ -0,0 +1,18 @@
+package statemachine
+
+import "context"
+
+// TransitHandler 执行一次流转，返回流转后的状态
+type TransitHandler[S, E ID, C any] func(ctx context.Context, info TransitionInfo[S, E, C]) (S, error)
+
+// Interceptor 拦截流转的执行，可以在调用 next 前后加入日志、监控、加锁、事务等逻辑，
+// 不调用 next 可以中止流转，没有返回错误时状态停留在 info.From，也可以修改 next 返回的状态和错误
+type Interceptor[S, E ID, C any] func(next TransitHandler[S, E, C]) TransitHandler[S, E, C]
+
+// chainInterceptors 按声明的顺序组合拦截器，第一个拦截器在最外层
+func chainInterceptors[S, E ID, C any](handler TransitHandler[S, E, C], interceptors ...Interceptor[S, E, C]) TransitHandler[S, E, C] {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		handler = interceptors[i](handler)
+	}
+	return handler
+}
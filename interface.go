@@ -1,5 +1,10 @@
 package statemachine
 
+import (
+	"context"
+	"time"
+)
+
 type ID interface {
 	~string | ~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
 }
@@ -12,37 +17,85 @@ type InternalTransitionBuilder[S, E ID, C any] interface {
 	Within(stateId S) To[S, E, C]
 }
 
+type LocalTransitionBuilder[S, E ID, C any] interface {
+	From(stateId ...S) From[S, E, C]
+}
+
 type From[S, E ID, C any] interface {
 	To(stateId S) To[S, E, C]
 }
 
 type To[S, E ID, C any] interface {
 	On(event E) On[S, E, C]
+	// After 在源状态上停留 d 之后，实例会自动触发超时事件 event
+	After(d time.Duration, event E) On[S, E, C]
 }
 
 type On[S, E ID, C any] interface {
 	When(condition Condition[C]) When[S, E, C]
+	// WhenNamed 设置带名字的条件，名字会显示在生成的状态图中
+	WhenNamed(name string, condition Condition[C]) When[S, E, C]
+	WhenContext(condition ContextCondition[C]) When[S, E, C]
 }
 
 type When[S, E ID, C any] interface {
+	Use(interceptors ...Interceptor[S, E, C]) When[S, E, C]
 	Perform(action Action[S, E, C])
+	PerformContext(action ContextAction[S, E, C])
+	// PerformNamed 设置带名字的动作，名字会显示在生成的状态图中
+	PerformNamed(name string, action Action[S, E, C])
+	// PerformPost 设置可以投递后续事件的动作
+	PerformPost(action PostAction[S, E, C])
 }
 
 type Condition[C any] func(ctx C) bool
 
 type Action[S, E ID, C any] func(from S, to S, event E, ctx C) error
 
+// ContextCondition 可以感知 context.Context 的条件
+type ContextCondition[C any] func(ctx context.Context, c C) bool
+
+// ContextAction 可以感知 context.Context 的动作，需要访问数据库或者外部服务时应该使用它来遵守超时和取消
+type ContextAction[S, E ID, C any] func(ctx context.Context, from S, to S, event E, c C) error
+
+// Poster 投递后续事件，事件会在当前流转完成后由同一个实例处理，事件类型和状态机的事件类型一致
+type Poster[E ID] func(event E) error
+
+// PostAction 可以投递后续事件的动作，只有通过实例触发时才能投递，否则 post 返回 ErrNoEventQueue
+type PostAction[S, E ID, C any] func(ctx context.Context, post Poster[E], from S, to S, event E, c C) error
+
 type FailCallback[S, E ID, C any] func(sourceState S, event E, ctx C)
 
+// DeferredFailCallback 状态改变后重新处理延迟事件失败时的回调，sourceState 是处理失败时实例的当前状态
+type DeferredFailCallback[S, E ID, C any] func(sourceState S, event E, ctx C, err error)
+
 type StateMachine[S, E ID, C any] interface {
 	// FireEvent 在状态 S 触发事件 E
 	FireEvent(stateId S, event E, ctx C) (S, error)
+	// FireEventContext 在状态 S 触发事件 E，ctx 被取消时返回 ErrCanceled 并且状态不变
+	FireEventContext(ctx context.Context, stateId S, event E, c C) (S, error)
 	// GetMachineId 获取状态机id
 	GetMachineId() string
-	// Verify 验证状态 S 是否可以触发事件 E
+	// Verify 验证状态 S 是否可以触发事件 E，终止状态和终止的复合状态的子状态不能触发任何事件
 	Verify(stateId S, event E) bool
+	// InitialState 获取声明的起始状态，没有声明时 ok 为 false
+	InitialState() (stateId S, ok bool)
+	// IsFinal 状态 S 是否是声明的终止状态，终止的复合状态的子状态也是终止状态
+	IsFinal(stateId S) bool
+	// NewInstance 创建一个当前状态为 S 的状态机实例，c 是超时事件在第一次触发事件之前使用的上下文
+	NewInstance(stateId S, c C) *Instance[S, E, C]
+	// NewEntityInstance 通过状态访问器读取实体的状态创建状态机实例
+	NewEntityInstance(entity C) (*Instance[S, E, C], error)
 	// ShowStateMachine 打印状态机结构
 	ShowStateMachine()
-	// GeneratePlantUML 生成PlantUML
-	GeneratePlantUML() string
+	// GeneratePlantUML 生成PlantUML，输出按状态和事件排序
+	GeneratePlantUML(options ...PlantUMLOption) string
+	// GenerateMermaid 生成 Mermaid stateDiagram-v2，输出按状态和事件排序
+	GenerateMermaid() string
+	// GenerateDOT 生成 Graphviz DOT，外部流转为实线，内部流转为虚线，本地流转为点线，输出按状态和事件排序
+	GenerateDOT(options ...DOTOption) string
+	// Export 导出状态机的声明式定义，可以序列化成 JSON 或 YAML 并通过 LoadJSON 或 LoadYAML 重新加载，
+	// 状态和事件按底层类型的字面值导出，默认的 FunctionRegistry 和 NameParser 都可以解析，
+	// 监听器、失败回调和状态访问器不属于结构，不会导出，定义无法表示的功能返回 ErrNotExportable
+	Export() (*Definition, error)
 }
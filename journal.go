@@ -0,0 +1,170 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrReplayMismatch 重放日志时状态机得到的状态和日志中记录的不一致
+var ErrReplayMismatch = NewError("journal replay mismatch")
+
+// Outcome 日志中记录的事件处理结果
+type Outcome int
+
+const (
+	// OutcomeTransitioned 事件处理成功，包括没有改变状态的内部流转
+	OutcomeTransitioned Outcome = iota + 1
+	// OutcomeDeferred 事件被延迟处理，状态改变后重新处理时会再记录一条日志
+	OutcomeDeferred
+	// OutcomeFailed 事件处理失败，状态没有改变
+	OutcomeFailed
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeTransitioned:
+		return "TRANSITIONED"
+	case OutcomeDeferred:
+		return "DEFERRED"
+	case OutcomeFailed:
+		return "FAILED"
+	}
+	return ""
+}
+
+// JournalEntry 实例处理一个事件的记录
+type JournalEntry[S, E ID] struct {
+	Event     E         `json:"event"`
+	From      S         `json:"from"`
+	To        S         `json:"to"`
+	Timestamp time.Time `json:"timestamp"`
+	Outcome   Outcome   `json:"outcome"`
+	// Context 序列化后的上下文，没有设置序列化方法时为空
+	Context []byte `json:"context,omitempty"`
+	// Error 处理失败时的错误信息
+	Error string `json:"error,omitempty"`
+}
+
+// Journal 保存实例处理过的事件，可以通过 Replay 重建状态
+type Journal[S, E ID] interface {
+	Append(ctx context.Context, entry JournalEntry[S, E]) error
+}
+
+// MemoryJournal 保存在内存中的 Journal，可以并发使用
+type MemoryJournal[S, E ID] struct {
+	mu      sync.Mutex
+	entries []JournalEntry[S, E]
+}
+
+// NewMemoryJournal 创建一个空的 MemoryJournal
+func NewMemoryJournal[S, E ID]() *MemoryJournal[S, E] {
+	return &MemoryJournal[S, E]{}
+}
+
+func (j *MemoryJournal[S, E]) Append(_ context.Context, entry JournalEntry[S, E]) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, entry)
+	return nil
+}
+
+// Entries 按追加的顺序返回所有记录
+func (j *MemoryJournal[S, E]) Entries() []JournalEntry[S, E] {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]JournalEntry[S, E](nil), j.entries...)
+}
+
+// Reset 用 entries 替换所有记录，实例从快照恢复时使用
+func (j *MemoryJournal[S, E]) Reset(entries []JournalEntry[S, E]) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append([]JournalEntry[S, E](nil), entries...)
+}
+
+// SetJournal 设置实例的日志，之后实例处理的每个事件都会追加到 journal 中
+// encode 用来序列化上下文，为 nil 时不记录上下文
+func (i *Instance[S, E, C]) SetJournal(journal Journal[S, E], encode func(c C) ([]byte, error)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.journal = journal
+	i.encode = encode
+}
+
+// record 追加一条日志，调用时必须持有实例锁
+func (i *Instance[S, E, C]) record(ctx context.Context, event E, from, to S, outcome Outcome, c C, cause error) error {
+	if i.journal == nil {
+		return nil
+	}
+	entry := JournalEntry[S, E]{
+		Event:     event,
+		From:      from,
+		To:        to,
+		Timestamp: i.machine.scheduler.Now(),
+		Outcome:   outcome,
+	}
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+	if i.encode != nil {
+		data, err := i.encode(c)
+		if err != nil {
+			return err
+		}
+		entry.Context = data
+	}
+	return i.journal.Append(ctx, entry)
+}
+
+// Replay 从 initial 开始按顺序重新执行日志中处理成功的事件，返回重建的状态
+// 重放时只计算条件，不执行流转动作、进入和离开动作，也不调用监听器和拦截器，所以条件不能有副作用
+// decode 用来反序列化日志中的上下文，为 nil 时使用零值
+// 重放得到的状态和日志中记录的不一致时返回 ErrReplayMismatch
+func Replay[S, E ID, C any](ctx context.Context, machine StateMachine[S, E, C], initial S, entries []JournalEntry[S, E], decode func(data []byte) (C, error)) (S, error) {
+	m, ok := machine.(*stateMachine[S, E, C])
+	if !ok {
+		return initial, NewError(fmt.Sprintf("state machine [%s] does not support replay", machine.GetMachineId()))
+	}
+	current := initial
+	for k, entry := range entries {
+		if entry.Outcome != OutcomeTransitioned {
+			continue
+		}
+		if entry.From != current {
+			return current, fmt.Errorf("%w: entry %d starts from %v, current state is %v", ErrReplayMismatch, k, entry.From, current)
+		}
+		var c C
+		if decode != nil && entry.Context != nil {
+			var err error
+			if c, err = decode(entry.Context); err != nil {
+				return current, err
+			}
+		}
+		to, err := m.replay(ctx, current, entry.Event, c)
+		if err != nil {
+			return current, err
+		}
+		if to != entry.To {
+			return current, fmt.Errorf("%w: entry %d event %v leads to %v, journal recorded %v", ErrReplayMismatch, k, entry.Event, to, entry.To)
+		}
+		current = to
+	}
+	return current, nil
+}
+
+// replay 计算在状态上触发事件的目标状态，不执行任何动作
+func (s *stateMachine[S, E, C]) replay(ctx context.Context, stateId S, event E, c C) (S, error) {
+	if s.finals[stateId] {
+		return stateId, &FinalStateError[S, E]{MachineId: s.machineId, State: stateId, Event: event}
+	}
+	if err := canceled(ctx); err != nil {
+		return stateId, err
+	}
+	transition, _ := s.routeTransition(ctx, stateId, event, c)
+	if transition == nil || transition.ty == INTERNAL {
+		return stateId, nil
+	}
+	return transition.target.id, nil
+}
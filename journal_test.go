@@ -0,0 +1,83 @@
+package statemachine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_journal(t *testing.T) {
+	var actions int
+	perform := func(from States, to States, event Events, c *Entity) error {
+		actions++
+		return nil
+	}
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetScheduler(NewManualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	builder.Defer(STATE2, EVENT4)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(perform)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).
+		When(func(c *Entity) bool { return c.Status == STATE2 }).Perform(perform)
+	builder.InternalTransition().Within(STATE3).On(EVENT4).When(nil).Perform(perform)
+	builder.ExternalTransition().From(STATE3).To(STATE1).On(EVENT3).When(nil).
+		Perform(func(from States, to States, event Events, c *Entity) error {
+			return errors.New("refund failed")
+		})
+	machine, err := builder.BuildUnregistered("TestStateMachine-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal := NewMemoryJournal[States, Events]()
+	instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	instance.SetJournal(journal, func(c *Entity) ([]byte, error) {
+		return json.Marshal(c)
+	})
+	for _, event := range []Events{EVENT1, EVENT4, EVENT2} {
+		entity := &Entity{Status: instance.Current()}
+		if _, err = instance.Fire(event, entity); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = instance.Fire(EVENT3, &Entity{Status: STATE3}); err == nil {
+		t.Error("Fire() should return the action error")
+	}
+
+	entries := journal.Entries()
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Event.String()+" "+entry.From.String()+"->"+entry.To.String()+" "+entry.Outcome.String())
+	}
+	want := []string{
+		"EVENT1 STATE1->STATE2 TRANSITIONED",
+		"EVENT4 STATE2->STATE2 DEFERRED",
+		"EVENT2 STATE2->STATE3 TRANSITIONED",
+		"EVENT4 STATE3->STATE3 TRANSITIONED",
+		"EVENT3 STATE3->STATE3 FAILED",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+	if entries[4].Error != "refund failed" || string(entries[0].Context) != `{"Status":1}` {
+		t.Errorf("entry = %+v", entries[4])
+	}
+
+	actions = 0
+	decode := func(data []byte) (*Entity, error) {
+		entity := &Entity{}
+		return entity, json.Unmarshal(data, entity)
+	}
+	current, err := Replay(context.Background(), machine, STATE1, entries, decode)
+	if err != nil || current != STATE3 {
+		t.Errorf("Replay() = %v, %v, want %v", current, err, STATE3)
+	}
+	if actions != 0 {
+		t.Errorf("Replay() performed %d actions", actions)
+	}
+	// 日志和状态机定义不一致
+	if _, err = Replay(context.Background(), machine, STATE2, entries, decode); !errors.Is(err, ErrReplayMismatch) {
+		t.Errorf("Replay() err = %v, want %v", err, ErrReplayMismatch)
+	}
+}
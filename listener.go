@@ -0,0 +1,36 @@
+package statemachine
+
+// TransitionInfo 一次流转的信息
+type TransitionInfo[S, E ID, C any] struct {
+	MachineId string
+	From      S
+	To        S
+	Event     E
+	Type      TransitionType
+	Context   C
+}
+
+// Listener 监听状态机的流转，可以用来做审计和监控
+type Listener[S, E ID, C any] interface {
+	// BeforeTransition 流转执行前调用
+	BeforeTransition(info TransitionInfo[S, E, C])
+	// AfterTransition 流转执行成功后调用
+	AfterTransition(info TransitionInfo[S, E, C])
+	// TransitionFailed 流转执行失败后调用
+	TransitionFailed(info TransitionInfo[S, E, C], err error)
+	// NoTransition 没有可以执行的流转时调用，info.To 和 info.From 相同
+	NoTransition(info TransitionInfo[S, E, C])
+}
+
+// NopListener Listener 的空实现，嵌入到结构体中只需要实现关心的回调
+type NopListener[S, E ID, C any] struct{}
+
+func (NopListener[S, E, C]) BeforeTransition(TransitionInfo[S, E, C]) {}
+
+func (NopListener[S, E, C]) AfterTransition(TransitionInfo[S, E, C]) {}
+
+func (NopListener[S, E, C]) TransitionFailed(TransitionInfo[S, E, C], error) {}
+
+func (NopListener[S, E, C]) NoTransition(TransitionInfo[S, E, C]) {}
+
+var _ Listener[int, int, int] = NopListener[int, int, int]{}
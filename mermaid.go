@@ -0,0 +1,48 @@
+package statemachine
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (s *stateMachine[S, E, C]) GenerateMermaid() string {
+	builder := strings.Builder{}
+	builder.WriteString("stateDiagram-v2\n")
+	states := s.stateMap.sorted()
+	for _, state := range states {
+		if state.parent == nil && len(state.children) != 0 {
+			writeMermaidCompositeState(&builder, state, "    ")
+		}
+	}
+	initials := s.initialStates()
+	for _, state := range states {
+		if initials[state.id] {
+			builder.WriteString(fmt.Sprintf("    [*] --> %v\n", state.id))
+		}
+	}
+	for _, state := range states {
+		for _, transition := range state.getSortedEventTransitions() {
+			// 内部流转画成指向自身的流转
+			builder.WriteString(fmt.Sprintf("    %v --> %v : %s\n", transition.source.id, transition.target.id, transition.label()))
+		}
+	}
+	terminals := s.terminalStates()
+	for _, state := range states {
+		if terminals[state.id] {
+			builder.WriteString(fmt.Sprintf("    %v --> [*]\n", state.id))
+		}
+	}
+	return builder.String()
+}
+
+func writeMermaidCompositeState[S, E ID, C any](builder *strings.Builder, state *state[S, E, C], indent string) {
+	builder.WriteString(fmt.Sprintf("%sstate %v {\n", indent, state.id))
+	for _, child := range sortStates(state.children) {
+		if len(child.children) != 0 {
+			writeMermaidCompositeState(builder, child, indent+"    ")
+		} else {
+			builder.WriteString(fmt.Sprintf("%s    %v\n", indent, child.id))
+		}
+	}
+	builder.WriteString(indent + "}\n")
+}
@@ -0,0 +1,96 @@
+package statemachine
+
+import (
+	"fmt"
+	"strings"
+)
+
+type plantUMLOptions struct {
+	title       string
+	skinParams  [][2]string
+	hideActions bool
+}
+
+// PlantUMLOption GeneratePlantUML 的选项
+type PlantUMLOption func(o *plantUMLOptions)
+
+// WithPlantUMLTitle 设置状态图的标题
+func WithPlantUMLTitle(title string) PlantUMLOption {
+	return func(o *plantUMLOptions) {
+		o.title = title
+	}
+}
+
+// WithPlantUMLSkinParam 添加 skinparam，按添加的顺序输出
+func WithPlantUMLSkinParam(name, value string) PlantUMLOption {
+	return func(o *plantUMLOptions) {
+		o.skinParams = append(o.skinParams, [2]string{name, value})
+	}
+}
+
+// WithPlantUMLHideActions 流转上不显示动作的名字
+func WithPlantUMLHideActions() PlantUMLOption {
+	return func(o *plantUMLOptions) {
+		o.hideActions = true
+	}
+}
+
+func (s *stateMachine[S, E, C]) GeneratePlantUML(options ...PlantUMLOption) string {
+	opts := &plantUMLOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	builder := strings.Builder{}
+	builder.WriteString("@startuml\n")
+	if opts.title != "" {
+		builder.WriteString("title " + opts.title + "\n")
+	}
+	for _, skinParam := range opts.skinParams {
+		builder.WriteString(fmt.Sprintf("skinparam %s %s\n", skinParam[0], skinParam[1]))
+	}
+	states := s.stateMap.sorted()
+	for _, state := range states {
+		if state.parent == nil && len(state.children) != 0 {
+			writePlantUMLCompositeState(&builder, state, "")
+		}
+	}
+	initials := s.initialStates()
+	for _, state := range states {
+		if initials[state.id] {
+			builder.WriteString(fmt.Sprintf("[*] --> %v\n", state.id))
+		}
+	}
+	for _, state := range states {
+		for _, transition := range state.getSortedEventTransitions() {
+			arrow := "-->"
+			if transition.ty == LOCAL {
+				arrow = "-[dashed]->"
+			}
+			label := transition.label()
+			if !opts.hideActions && transition.actionName != "" {
+				label += " / " + transition.actionName
+			}
+			builder.WriteString(fmt.Sprintf("%v %s %v : %s\n", transition.source.id, arrow, transition.target.id, label))
+		}
+	}
+	terminals := s.terminalStates()
+	for _, state := range states {
+		if terminals[state.id] {
+			builder.WriteString(fmt.Sprintf("%v --> [*]\n", state.id))
+		}
+	}
+	builder.WriteString("@enduml")
+	return builder.String()
+}
+
+func writePlantUMLCompositeState[S, E ID, C any](builder *strings.Builder, state *state[S, E, C], indent string) {
+	builder.WriteString(fmt.Sprintf("%sstate %v {\n", indent, state.id))
+	for _, child := range sortStates(state.children) {
+		if len(child.children) != 0 {
+			writePlantUMLCompositeState(builder, child, indent+"  ")
+		} else {
+			builder.WriteString(fmt.Sprintf("%s  state %v\n", indent, child.id))
+		}
+	}
+	builder.WriteString(indent + "}\n")
+}
@@ -0,0 +1,108 @@
+package statemachine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Timer Scheduler 创建的定时器
+type Timer interface {
+	// Stop 停止定时器，定时器已经触发或者已经停止时返回 false
+	Stop() bool
+}
+
+// Scheduler 提供当前时间和定时器，用于实现超时流转，测试中可以使用 ManualScheduler 控制时间
+type Scheduler interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// realScheduler 使用系统时间的 Scheduler
+type realScheduler struct{}
+
+func (realScheduler) Now() time.Time {
+	return time.Now()
+}
+
+func (realScheduler) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// ManualScheduler 手动推进时间的 Scheduler，定时器只会在 Advance 中按到期的顺序同步触发
+type ManualScheduler struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	timers []*manualTimer
+}
+
+// NewManualScheduler 创建当前时间为 now 的 ManualScheduler
+func NewManualScheduler(now time.Time) *ManualScheduler {
+	return &ManualScheduler{now: now}
+}
+
+func (s *ManualScheduler) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+func (s *ManualScheduler) AfterFunc(d time.Duration, f func()) Timer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	timer := &manualTimer{scheduler: s, deadline: s.now.Add(d), seq: s.seq, f: f}
+	s.timers = append(s.timers, timer)
+	return timer
+}
+
+// Advance 把时间推进 d，触发期间到期的定时器，定时器中创建的新定时器到期时也会被触发
+func (s *ManualScheduler) Advance(d time.Duration) {
+	s.mu.Lock()
+	end := s.now.Add(d)
+	s.mu.Unlock()
+	for {
+		s.mu.Lock()
+		sort.Slice(s.timers, func(i, j int) bool {
+			if s.timers[i].deadline.Equal(s.timers[j].deadline) {
+				return s.timers[i].seq < s.timers[j].seq
+			}
+			return s.timers[i].deadline.Before(s.timers[j].deadline)
+		})
+		if len(s.timers) == 0 || s.timers[0].deadline.After(end) {
+			s.now = end
+			s.mu.Unlock()
+			return
+		}
+		timer := s.timers[0]
+		s.timers = s.timers[1:]
+		s.now = timer.deadline
+		s.mu.Unlock()
+		// 不持有锁执行回调，回调中可以继续创建定时器
+		timer.f()
+	}
+}
+
+type manualTimer struct {
+	scheduler *ManualScheduler
+	deadline  time.Time
+	seq       int
+	f         func()
+}
+
+func (t *manualTimer) Stop() bool {
+	s := t.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, timer := range s.timers {
+		if timer == t {
+			s.timers = append(s.timers[:k], s.timers[k+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+var _ Scheduler = realScheduler{}
+var _ Scheduler = (*ManualScheduler)(nil)
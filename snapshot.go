@@ -0,0 +1,163 @@
+package statemachine
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// snapshotVersion 快照格式的版本号，格式不兼容的修改需要增加版本号
+const snapshotVersion = 1
+
+// ErrInvalidSnapshot 快照的版本不支持，或者快照中的状态和事件在当前状态机中不存在
+var ErrInvalidSnapshot = NewError("invalid snapshot")
+
+// Snapshot 实例运行时状态的快照
+type Snapshot[S, E ID] struct {
+	Version   int    `json:"version"`
+	MachineId string `json:"machineId"`
+	Current   S      `json:"current"`
+	// Deferred 等待处理的延迟事件
+	Deferred []DeferredSnapshot[E] `json:"deferred,omitempty"`
+	// Timers 等待触发的超时事件
+	Timers []TimerSnapshot[E] `json:"timers,omitempty"`
+	// History 实例处理过的事件，只有实例的日志支持读取记录时才会保存
+	History []JournalEntry[S, E] `json:"history,omitempty"`
+}
+
+// TimerSnapshot 等待触发的超时事件，Deadline 是事件触发的时间
+type TimerSnapshot[E ID] struct {
+	Event    E         `json:"event"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// DeferredSnapshot 等待处理的延迟事件，Context 是触发事件时的上下文序列化成的 JSON，重新处理时使用
+type DeferredSnapshot[E ID] struct {
+	Event   E               `json:"event"`
+	Context json.RawMessage `json:"context"`
+}
+
+// historyJournal 可以读取和替换记录的日志，快照会保存和恢复它的记录
+type historyJournal[S, E ID] interface {
+	Journal[S, E]
+	Entries() []JournalEntry[S, E]
+	Reset(entries []JournalEntry[S, E])
+}
+
+// Snapshot 把实例的当前状态、延迟事件、等待触发的超时事件和事件记录序列化成 JSON
+// 延迟事件的上下文按 JSON 序列化，上下文不能序列化时返回错误
+func (i *Instance[S, E, C]) Snapshot() ([]byte, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	snapshot := Snapshot[S, E]{
+		Version:   snapshotVersion,
+		MachineId: i.machine.machineId,
+		Current:   i.current,
+	}
+	for _, d := range i.deferred {
+		data, err := json.Marshal(d.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot context of deferred event %v: %w", d.event, err)
+		}
+		snapshot.Deferred = append(snapshot.Deferred, DeferredSnapshot[E]{Event: d.event, Context: data})
+	}
+	for _, pending := range i.timers {
+		snapshot.Timers = append(snapshot.Timers, TimerSnapshot[E]{Event: pending.event, Deadline: pending.deadline})
+	}
+	if journal, ok := i.journal.(historyJournal[S, E]); ok {
+		snapshot.History = journal.Entries()
+	}
+	return json.Marshal(snapshot)
+}
+
+// Restore 从 Snapshot 生成的 JSON 恢复实例，替换当前状态、延迟事件和超时事件，c 是恢复后超时事件使用的上下文，
+// 延迟事件使用快照中保存的上下文
+// 快照中的状态和事件在当前状态机中不存在或者上下文不能反序列化时返回 ErrInvalidSnapshot，实例保持不变
+// 已经过期的超时事件会在恢复后立即触发
+func (i *Instance[S, E, C]) Restore(data []byte, c C) error {
+	snapshot := Snapshot[S, E]{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	if err := i.machine.validateSnapshot(&snapshot); err != nil {
+		return err
+	}
+	var deferred []deferredEvent[E, C]
+	for _, d := range snapshot.Deferred {
+		var dc C
+		if len(d.Context) != 0 {
+			if err := json.Unmarshal(d.Context, &dc); err != nil {
+				return fmt.Errorf("%w: context of deferred event %v: %w", ErrInvalidSnapshot, d.Event, err)
+			}
+		}
+		deferred = append(deferred, deferredEvent[E, C]{event: d.Event, ctx: dc})
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.stopTimers()
+	i.current = snapshot.Current
+	i.ctx = c
+	i.deferred = deferred
+	now := i.machine.scheduler.Now()
+	for _, t := range snapshot.Timers {
+		after := t.Deadline.Sub(now)
+		if after < 0 {
+			after = 0
+		}
+		owner, _ := i.machine.timeoutOwner(snapshot.Current, t.Event)
+		i.startTimer(owner, t.Event, after)
+	}
+	if journal, ok := i.journal.(historyJournal[S, E]); ok && snapshot.History != nil {
+		journal.Reset(snapshot.History)
+	}
+	return nil
+}
+
+// validateSnapshot 校验快照的版本，以及快照中的状态和事件在状态机中是否存在
+func (s *stateMachine[S, E, C]) validateSnapshot(snapshot *Snapshot[S, E]) error {
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, snapshot.Version)
+	}
+	if snapshot.MachineId != s.machineId {
+		return fmt.Errorf("%w: snapshot of state machine [%s] can not be restored to [%s]", ErrInvalidSnapshot, snapshot.MachineId, s.machineId)
+	}
+	if s.stateMap.get(snapshot.Current) == nil {
+		return fmt.Errorf("%w: state %v not found", ErrInvalidSnapshot, snapshot.Current)
+	}
+	for _, d := range snapshot.Deferred {
+		if !s.hasEvent(d.Event) {
+			return fmt.Errorf("%w: deferred event %v not found", ErrInvalidSnapshot, d.Event)
+		}
+	}
+	for _, t := range snapshot.Timers {
+		if _, ok := s.timeoutOwner(snapshot.Current, t.Event); !ok {
+			return fmt.Errorf("%w: timeout event %v not found in state %v", ErrInvalidSnapshot, t.Event, snapshot.Current)
+		}
+	}
+	for k, entry := range snapshot.History {
+		if s.stateMap.get(entry.From) == nil || s.stateMap.get(entry.To) == nil || !s.hasEvent(entry.Event) {
+			return fmt.Errorf("%w: history entry %d %v %v -> %v not found", ErrInvalidSnapshot, k, entry.Event, entry.From, entry.To)
+		}
+	}
+	return nil
+}
+
+// hasEvent 状态机中是否有流转或者延迟处理使用了事件
+func (s *stateMachine[S, E, C]) hasEvent(event E) bool {
+	for _, state := range s.stateMap {
+		if len(state.getEventTransitions(event)) != 0 || state.deferred[event] {
+			return true
+		}
+	}
+	return false
+}
+
+// timeoutOwner 返回状态或者父状态中声明了事件对应的超时流转的状态
+func (s *stateMachine[S, E, C]) timeoutOwner(stateId S, event E) (r S, ok bool) {
+	for st := s.stateMap.get(stateId); st != nil; st = st.parent {
+		if _, ok = st.timeoutOf(event); ok {
+			return st.id, true
+		}
+	}
+	return r, false
+}
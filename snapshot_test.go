@@ -0,0 +1,126 @@
+package statemachine
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_snapshot(t *testing.T) {
+	scheduler := NewManualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetScheduler(scheduler)
+	builder.Defer(STATE2, EVENT4)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(nil)
+	builder.ExternalTransition().From(STATE1).To(STATE4).After(30*time.Minute, EVENT4).When(nil).Perform(nil)
+	builder.ExternalTransition().From(STATE2).To(STATE3).After(7*24*time.Hour, EVENT3).When(nil).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	instance.SetJournal(NewMemoryJournal[States, Events](), nil)
+	for _, event := range []Events{EVENT1, EVENT4} {
+		if _, err = instance.Fire(event, &Entity{Status: STATE4}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scheduler.Advance(24 * time.Hour)
+	data, err := instance.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.Stop()
+
+	journal := NewMemoryJournal[States, Events]()
+	restored := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	restored.SetJournal(journal, nil)
+	if err = restored.Restore(data, &Entity{Status: STATE2}); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Current() != STATE2 {
+		t.Errorf("Current() = %v, want %v", restored.Current(), STATE2)
+	}
+	if deferred := restored.Deferred(); !reflect.DeepEqual(deferred, []Events{EVENT4}) {
+		t.Errorf("Deferred() = %v, want [%v]", deferred, Events(EVENT4))
+	}
+	// 延迟事件的上下文也会被恢复
+	if c := restored.deferred[0].ctx; c == nil || c.Status != STATE4 {
+		t.Errorf("deferred context = %v, want %v", c, &Entity{Status: STATE4})
+	}
+	if entries := journal.Entries(); len(entries) != 2 || entries[1].Outcome != OutcomeDeferred {
+		t.Errorf("Entries() = %v", entries)
+	}
+	// 起始状态上的超时事件已经被替换，剩下 6 天后自动确认
+	scheduler.Advance(6*24*time.Hour - time.Minute)
+	if restored.Current() != STATE2 {
+		t.Errorf("Current() = %v, want %v", restored.Current(), STATE2)
+	}
+	scheduler.Advance(time.Minute)
+	if restored.Current() != STATE3 {
+		t.Errorf("Current() = %v, want %v", restored.Current(), STATE3)
+	}
+
+	invalid := []string{
+		strings.Replace(string(data), `"version":1`, `"version":2`, 1),
+		strings.Replace(string(data), `"current":2`, `"current":9`, 1),
+		strings.Replace(string(data), `"deferred":[{"event":4`, `"deferred":[{"event":9`, 1),
+		strings.Replace(string(data), `"context":{"Status":4}`, `"context":[]`, 1),
+		strings.Replace(string(data), `"event":3`, `"event":1`, 1),
+		"{",
+	}
+	for _, payload := range invalid {
+		if payload == string(data) {
+			t.Fatalf("payload not modified: %s", payload)
+		}
+		other := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+		if err = other.Restore([]byte(payload), &Entity{Status: STATE1}); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Errorf("Restore(%s) err = %v, want %v", payload, err, ErrInvalidSnapshot)
+		}
+		if other.Current() != STATE1 {
+			t.Errorf("Current() = %v, want %v", other.Current(), STATE1)
+		}
+		other.Stop()
+	}
+}
+
+func Test_snapshotOverdueTimer(t *testing.T) {
+	scheduler := NewManualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.SetScheduler(scheduler)
+	builder.SetStateAccessor(func(c *Entity) States {
+		return c.Status
+	}, func(c *Entity, stateId States) {
+		c.Status = stateId
+	})
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(nil)
+	builder.ExternalTransition().From(STATE2).To(STATE3).After(time.Hour, EVENT3).
+		When(func(c *Entity) bool { return c.Status == STATE2 }).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-snapshotOverdueTimer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := machine.NewInstance(STATE1, &Entity{Status: STATE1})
+	if _, err = instance.Fire(EVENT1, &Entity{Status: STATE1}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := instance.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.Stop()
+
+	// 进程重启期间超时事件已经过期，恢复后使用 Restore 传入的实体立即触发
+	scheduler.Advance(2 * time.Hour)
+	entity := &Entity{Status: STATE2}
+	restored := machine.NewInstance(STATE2, entity)
+	if err = restored.Restore(data, entity); err != nil {
+		t.Fatal(err)
+	}
+	scheduler.Advance(0)
+	if restored.Current() != STATE3 || entity.Status != STATE3 {
+		t.Errorf("Current() = %v, entity = %v, want %v", restored.Current(), entity.Status, STATE3)
+	}
+}
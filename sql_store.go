@@ -0,0 +1,90 @@
+package statemachine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+type sqlStoreOptions struct {
+	placeholder func(n int) string
+}
+
+// SQLStoreOption SQLStateStore 的选项
+type SQLStoreOption func(o *sqlStoreOptions)
+
+// WithSQLPlaceholder 设置第 n 个参数的占位符，默认是 ?，PostgreSQL 可以使用 $n
+func WithSQLPlaceholder(placeholder func(n int) string) SQLStoreOption {
+	return func(o *sqlStoreOptions) {
+		o.placeholder = placeholder
+	}
+}
+
+// SQLStateStore 使用 database/sql 保存状态的 StateStore，表结构为：
+//
+//	CREATE TABLE <table> (
+//	    state_key VARCHAR(255) PRIMARY KEY,
+//	    state     <与状态类型对应的列类型> NOT NULL,
+//	    version   BIGINT NOT NULL
+//	)
+type SQLStateStore[S ID] struct {
+	db         *sql.DB
+	loadSQL    string
+	insertSQL  string
+	updateSQL  string
+	versionSQL string
+}
+
+// NewSQLStateStore 创建一个使用 db 中 table 表保存状态的 SQLStateStore
+func NewSQLStateStore[S ID](db *sql.DB, table string, options ...SQLStoreOption) *SQLStateStore[S] {
+	opts := &sqlStoreOptions{placeholder: func(int) string { return "?" }}
+	for _, option := range options {
+		option(opts)
+	}
+	p := opts.placeholder
+	return &SQLStateStore[S]{
+		db:         db,
+		loadSQL:    fmt.Sprintf("SELECT state, version FROM %s WHERE state_key = %s", table, p(1)),
+		insertSQL:  fmt.Sprintf("INSERT INTO %s (state_key, state, version) VALUES (%s, %s, 1)", table, p(1), p(2)),
+		updateSQL:  fmt.Sprintf("UPDATE %s SET state = %s, version = version + 1 WHERE state_key = %s AND version = %s", table, p(1), p(2), p(3)),
+		versionSQL: fmt.Sprintf("SELECT version FROM %s WHERE state_key = %s", table, p(1)),
+	}
+}
+
+func (s *SQLStateStore[S]) Load(ctx context.Context, key string) (r S, version int64, err error) {
+	err = s.db.QueryRowContext(ctx, s.loadSQL, key).Scan(&r, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, 0, ErrStateNotFound
+	}
+	return r, version, err
+}
+
+func (s *SQLStateStore[S]) Save(ctx context.Context, key string, stateId S, version int64) error {
+	if version == 0 {
+		_, err := s.db.ExecContext(ctx, s.insertSQL, key, stateId)
+		if err == nil {
+			return nil
+		}
+		// 插入失败时如果记录已经存在，说明被其他人先创建了
+		var current int64
+		if s.db.QueryRowContext(ctx, s.versionSQL, key).Scan(&current) == nil {
+			return ErrVersionConflict
+		}
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, s.updateSQL, stateId, key, version)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+var _ StateStore[int] = (*SQLStateStore[int])(nil)
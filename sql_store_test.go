@@ -0,0 +1,32 @@
+//go:build cgo
+
+package statemachine
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSQLiteStateStore 创建使用内存 SQLite 的 SQLStateStore，SQLite 驱动需要 cgo，所以这个文件只在开启 cgo 时编译
+func newSQLiteStateStore(t *testing.T) StateStore[States] {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	// 内存数据库只在一个连接中有效
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE order_state (state_key VARCHAR(255) PRIMARY KEY, state INTEGER NOT NULL, version BIGINT NOT NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLStateStore[States](db, "order_state")
+}
+
+func Test_sqlStateStore(t *testing.T) {
+	testStateStore(t, newSQLiteStateStore(t))
+}
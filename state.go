@@ -1,6 +1,10 @@
 package statemachine
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"time"
+)
 
 func newState[S, E ID, C any](stateId S) *state[S, E, C] {
 	return &state[S, E, C]{
@@ -11,7 +15,15 @@ func newState[S, E ID, C any](stateId S) *state[S, E, C] {
 
 type state[S, E ID, C any] struct {
 	id               S
+	parent           *state[S, E, C]
+	children         []*state[S, E, C]
 	eventTransitions *eventTransitions[S, E, C]
+	entryAction      Action[S, E, C]
+	exitAction       Action[S, E, C]
+	// deferred 在当前状态下延迟处理的事件
+	deferred map[E]bool
+	// timeouts 在当前状态停留超过指定时间后触发的事件
+	timeouts []*timeout[E]
 }
 
 func (s *state[S, E, C]) addTransition(event E, target *state[S, E, C], transitionType TransitionType) (*Transition[S, E, C], error) {
@@ -36,6 +48,78 @@ func (s *state[S, E, C]) getAllEventTransitions() []*Transition[S, E, C] {
 	return s.eventTransitions.all()
 }
 
+func (s *state[S, E, C]) getSortedEventTransitions() []*Transition[S, E, C] {
+	return s.eventTransitions.sorted()
+}
+
+// timeoutOf 返回状态上事件对应的超时时间
+func (s *state[S, E, C]) timeoutOf(event E) (time.Duration, bool) {
+	for _, t := range s.timeouts {
+		if t.event == event {
+			return t.after, true
+		}
+	}
+	return 0, false
+}
+
+// isDeferred 事件是否在当前状态或父状态上被声明为延迟处理
+func (s *state[S, E, C]) isDeferred(event E) bool {
+	for st := s; st != nil; st = st.parent {
+		if st.deferred[event] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOutgoing 自身或父状态上是否有流向其他状态的流转
+func (s *state[S, E, C]) hasOutgoing() bool {
+	for st := s; st != nil; st = st.parent {
+		for _, transition := range st.getAllEventTransitions() {
+			if transition.ty != INTERNAL && transition.target != s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setParent 把当前状态挂到复合状态 parent 下
+func (s *state[S, E, C]) setParent(parent *state[S, E, C]) error {
+	if s.parent == parent {
+		return nil
+	}
+	if s.parent != nil {
+		return NewError(fmt.Sprintf("State '%s' already belongs to composite state '%s'", s, s.parent))
+	}
+	if s == parent || parent.isDescendantOf(s) {
+		return NewError(fmt.Sprintf("State '%s' can not be a child of '%s', it would create a cycle", s, parent))
+	}
+	s.parent = parent
+	parent.children = append(parent.children, s)
+	return nil
+}
+
+// isDescendantOf 当前状态是否是 o 的子孙状态
+func (s *state[S, E, C]) isDescendantOf(o *state[S, E, C]) bool {
+	for p := s.parent; p != nil; p = p.parent {
+		if p == o {
+			return true
+		}
+	}
+	return false
+}
+
+// commonAncestor 返回 s 和 o 最近的公共祖先，包括它们自身
+func (s *state[S, E, C]) commonAncestor(o *state[S, E, C]) *state[S, E, C] {
+	for p := s; p != nil; p = p.parent {
+		if p == o || o.isDescendantOf(p) {
+			return p
+		}
+	}
+	return nil
+}
+
 func (s *state[S, E, C]) String() string {
 	return fmt.Sprintf("%v", s.id)
 }
@@ -43,3 +127,12 @@ func (s *state[S, E, C]) String() string {
 func (s *state[S, E, C]) equals(o *state[S, E, C]) bool {
 	return s.id == o.id
 }
+
+// sortStates 返回按状态 id 排序的副本
+func sortStates[S, E ID, C any](states []*state[S, E, C]) []*state[S, E, C] {
+	res := append([]*state[S, E, C](nil), states...)
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].id < res[j].id
+	})
+	return res
+}
@@ -1,21 +1,40 @@
 package statemachine
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 )
 
+const defaultMaxChainDepth = 10
+
 type stateMachine[S, E ID, C any] struct {
 	machineId    string
 	stateMap     stateMap[S, E, C]
+	initial      *state[S, E, C]
+	finals       map[S]bool
 	ready        bool
 	failCallback FailCallback[S, E, C]
+	listeners    []Listener[S, E, C]
+	interceptors []Interceptor[S, E, C]
+	stateGetter  func(c C) S
+	stateSetter  func(c C, stateId S)
+	scheduler    Scheduler
+	strict       bool
 	err          error
+	// maxChainDepth 实例处理投递的事件链的最大深度
+	maxChainDepth int
+	// deferredFailCallback 重新处理延迟事件失败时的回调
+	deferredFailCallback DeferredFailCallback[S, E, C]
 }
 
 func newStateMachine[S, E ID, C any](stateMap stateMap[S, E, C]) *stateMachine[S, E, C] {
 	return &stateMachine[S, E, C]{
-		stateMap: stateMap,
+		stateMap:      stateMap,
+		finals:        make(map[S]bool),
+		scheduler:     realScheduler{},
+		maxChainDepth: defaultMaxChainDepth,
 	}
 }
 
@@ -23,36 +42,145 @@ func (s *stateMachine[S, E, C]) GetMachineId() string {
 	return s.machineId
 }
 
-func (s *stateMachine[S, E, C]) FireEvent(stateId S, event E, ctx C) (r S, err error) {
+func (s *stateMachine[S, E, C]) FireEvent(stateId S, event E, ctx C) (S, error) {
+	return s.FireEventContext(context.Background(), stateId, event, ctx)
+}
+
+func (s *stateMachine[S, E, C]) FireEventContext(ctx context.Context, stateId S, event E, c C) (S, error) {
+	to, _, err := s.fireEvent(ctx, stateId, event, c)
+	return to, err
+}
+
+// fireEvent 同 FireEventContext，同时返回执行的流转，没有可以执行的流转时为 nil
+func (s *stateMachine[S, E, C]) fireEvent(ctx context.Context, stateId S, event E, c C) (r S, transition *Transition[S, E, C], err error) {
 	if !s.ready {
-		return r, NewError("状态机尚未构建，不能工作")
+		return r, nil, NewError("状态机尚未构建，不能工作")
+	}
+	if s.IsFinal(stateId) {
+		return stateId, nil, &FinalStateError[S, E]{MachineId: s.machineId, State: stateId, Event: event}
 	}
-	transition := s.routeTransition(stateId, event, ctx)
-	// 没有找到对应的transition，可能是没定义，也可能是条件满足
+	transition, found := s.routeTransition(ctx, stateId, event, c)
+	// 没有找到对应的transition，可能是没定义，也可能是条件不满足
 	if transition == nil {
+		info := TransitionInfo[S, E, C]{MachineId: s.machineId, From: stateId, To: stateId, Event: event, Context: c}
+		for _, listener := range s.listeners {
+			listener.NoTransition(info)
+		}
 		if s.failCallback != nil {
-			s.failCallback(stateId, event, ctx)
+			s.failCallback(stateId, event, c)
+		}
+		if !s.strict {
+			return stateId, nil, nil
 		}
-		return stateId, nil
+		if found {
+			return stateId, nil, &GuardRejectedError[S, E]{MachineId: s.machineId, State: stateId, Event: event}
+		}
+		return stateId, nil, &TransitionNotFoundError[S, E]{MachineId: s.machineId, State: stateId, Event: event}
+	}
+	info := TransitionInfo[S, E, C]{MachineId: s.machineId, From: stateId, To: transition.target.id, Event: event, Type: transition.ty, Context: c}
+	if transition.ty == INTERNAL {
+		info.To = stateId
+	}
+	for _, listener := range s.listeners {
+		listener.BeforeTransition(info)
+	}
+	current := s.stateMap.get(stateId)
+	executed := false
+	handler := func(ctx context.Context, info TransitionInfo[S, E, C]) (r S, err error) {
+		executed = true
+		state, err := transition.transit(ctx, current, info.Context, false)
+		if err != nil {
+			return r, s.wrapActionError(info, err)
+		}
+		return state.id, nil
 	}
-	state, err := transition.transit(ctx, false)
+	interceptors := append(s.interceptors[:len(s.interceptors):len(s.interceptors)], transition.interceptors...)
+	to, err := chainInterceptors(handler, interceptors...)(ctx, info)
 	if err != nil {
-		return r, err
+		for _, listener := range s.listeners {
+			listener.TransitionFailed(info, err)
+		}
+		return r, nil, err
+	}
+	// 拦截器没有调用 next 时流转没有执行，忽略它返回的状态，停留在源状态
+	if !executed {
+		info.To = stateId
+		for _, listener := range s.listeners {
+			listener.AfterTransition(info)
+		}
+		return stateId, nil, nil
 	}
-	return state.id, nil
+	info.To = to
+	for _, listener := range s.listeners {
+		listener.AfterTransition(info)
+	}
+	return to, transition, nil
+}
+
+// wrapActionError 严格模式下把动作返回的错误包装成 ActionFailedError，否则原样返回
+func (s *stateMachine[S, E, C]) wrapActionError(info TransitionInfo[S, E, C], err error) error {
+	var ae *actionError
+	if !errors.As(err, &ae) {
+		return err
+	}
+	if !s.strict {
+		return ae.err
+	}
+	return &ActionFailedError[S, E]{MachineId: info.MachineId, From: info.From, To: info.To, Event: info.Event, Err: ae.err}
 }
 
 func (s *stateMachine[S, E, C]) Verify(stateId S, event E) bool {
-	transitions := s.getEventTransitions(stateId, event)
-	return len(transitions) != 0
+	if s.IsFinal(stateId) {
+		return false
+	}
+	for st := s.stateMap.get(stateId); st != nil; st = st.parent {
+		if len(st.getEventTransitions(event)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldDefer 状态上没有事件对应的流转，并且事件被声明为延迟处理
+func (s *stateMachine[S, E, C]) shouldDefer(stateId S, event E) bool {
+	state := s.stateMap.get(stateId)
+	return state != nil && state.isDeferred(event) && !s.Verify(stateId, event)
+}
+
+func (s *stateMachine[S, E, C]) InitialState() (r S, ok bool) {
+	if s.initial == nil {
+		return r, false
+	}
+	return s.initial.id, true
+}
+
+func (s *stateMachine[S, E, C]) IsFinal(stateId S) bool {
+	// 终止的复合状态的子状态也是终止状态
+	for st := s.stateMap.get(stateId); st != nil; st = st.parent {
+		if s.finals[st.id] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stateMachine[S, E, C]) NewInstance(stateId S, c C) *Instance[S, E, C] {
+	return newInstance(s, stateId, c)
+}
+
+func (s *stateMachine[S, E, C]) NewEntityInstance(entity C) (*Instance[S, E, C], error) {
+	if s.stateGetter == nil {
+		return nil, NewError(fmt.Sprintf("状态机 [%s] 没有设置状态访问器，不能从实体创建实例", s.machineId))
+	}
+	return newInstance(s, s.stateGetter(entity), entity), nil
 }
 
 func (s *stateMachine[S, E, C]) ShowStateMachine() {
 	builder := strings.Builder{}
-	builder.WriteString("-----StateMachine:" + s.machineId + "-------")
-	for stateId, state := range s.stateMap {
-		builder.WriteString(fmt.Sprintf("State: %v\n", stateId))
-		for _, transition := range state.getAllEventTransitions() {
+	builder.WriteString("-----StateMachine:" + s.machineId + "-------\n")
+	for _, state := range s.stateMap.sorted() {
+		builder.WriteString(fmt.Sprintf("State: %v\n", state.id))
+		for _, transition := range state.getSortedEventTransitions() {
 			builder.WriteString(fmt.Sprintf("    Transition:%s\n", transition))
 		}
 	}
@@ -60,20 +188,20 @@ func (s *stateMachine[S, E, C]) ShowStateMachine() {
 	fmt.Println(builder.String())
 }
 
-func (s *stateMachine[S, E, C]) GeneratePlantUML() string {
-	builder := strings.Builder{}
-	builder.WriteString("@startuml\n")
-	for _, state := range s.stateMap {
-		for _, transition := range state.getAllEventTransitions() {
-			builder.WriteString(fmt.Sprintf("%v --> %v : %v\n", transition.source.id, transition.target.id, transition.event))
+// routeTransition 查找状态上可以执行的流转，子状态没有匹配时依次使用父状态上定义的流转
+// found 表示状态或者父状态上是否定义了事件对应的流转
+func (s *stateMachine[S, E, C]) routeTransition(ctx context.Context, stateId S, event E, c C) (transit *Transition[S, E, C], found bool) {
+	for st := s.stateMap.get(stateId); st != nil; st = st.parent {
+		transitions := st.getEventTransitions(event)
+		found = found || len(transitions) != 0
+		if transit = s.selectTransition(ctx, transitions, c); transit != nil {
+			return transit, found
 		}
 	}
-	builder.WriteString("@enduml")
-	return builder.String()
+	return nil, found
 }
 
-func (s *stateMachine[S, E, C]) routeTransition(stateId S, event E, ctx C) *Transition[S, E, C] {
-	transitions := s.getEventTransitions(stateId, event)
+func (s *stateMachine[S, E, C]) selectTransition(ctx context.Context, transitions []*Transition[S, E, C], c C) *Transition[S, E, C] {
 	if len(transitions) == 0 {
 		return nil
 	}
@@ -81,7 +209,7 @@ func (s *stateMachine[S, E, C]) routeTransition(stateId S, event E, ctx C) *Tran
 	for _, transition := range transitions {
 		if transition.condition == nil {
 			transit = transition
-		} else if transition.condition(ctx) {
+		} else if transition.condition(ctx, c) {
 			transit = transition
 			break
 		}
@@ -89,13 +217,71 @@ func (s *stateMachine[S, E, C]) routeTransition(stateId S, event E, ctx C) *Tran
 	return transit
 }
 
-func (s *stateMachine[S, E, C]) createAndGetState(stateId S) *state[S, E, C] {
-	return s.stateMap.createAndGet(stateId)
+// verify 校验所有流转，复合状态可能在流转之后声明，所以在构建时统一校验
+func (s *stateMachine[S, E, C]) verify() error {
+	for _, state := range s.stateMap {
+		// 终止状态不能触发事件，从终止状态出发的流转永远不会执行
+		if len(state.getAllEventTransitions()) != 0 && s.IsFinal(state.id) {
+			return NewError(fmt.Sprintf("Final state '%s' can not have transitions.", state))
+		}
+		for _, transition := range state.getAllEventTransitions() {
+			if err := transition.verify(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func (s *stateMachine[S, E, C]) getEventTransitions(stateId S, event E) []*Transition[S, E, C] {
-	sourceState := s.stateMap.createAndGet(stateId)
-	return sourceState.getEventTransitions(event)
+// clone 复制状态机的结构和配置，Builder 每次构建都返回新的状态机，构建之后继续修改 Builder 不会影响已经构建的状态机
+func (s *stateMachine[S, E, C]) clone() *stateMachine[S, E, C] {
+	m := *s
+	m.stateMap = make(stateMap[S, E, C], len(s.stateMap))
+	for stateId, st := range s.stateMap {
+		copied := newState[S, E, C](stateId)
+		copied.entryAction = st.entryAction
+		copied.exitAction = st.exitAction
+		copied.timeouts = append([]*timeout[E](nil), st.timeouts...)
+		if st.deferred != nil {
+			copied.deferred = make(map[E]bool, len(st.deferred))
+			for event := range st.deferred {
+				copied.deferred[event] = true
+			}
+		}
+		m.stateMap[stateId] = copied
+	}
+	for stateId, st := range s.stateMap {
+		copied := m.stateMap[stateId]
+		if st.parent != nil {
+			copied.parent = m.stateMap[st.parent.id]
+		}
+		for _, child := range st.children {
+			copied.children = append(copied.children, m.stateMap[child.id])
+		}
+		for event, transitions := range st.eventTransitions.eventTransitions {
+			for _, transition := range transitions {
+				t := *transition
+				t.source = m.stateMap[transition.source.id]
+				t.target = m.stateMap[transition.target.id]
+				t.interceptors = append([]Interceptor[S, E, C](nil), transition.interceptors...)
+				copied.eventTransitions.eventTransitions[event] = append(copied.eventTransitions.eventTransitions[event], &t)
+			}
+		}
+	}
+	if s.initial != nil {
+		m.initial = m.stateMap[s.initial.id]
+	}
+	m.finals = make(map[S]bool, len(s.finals))
+	for stateId := range s.finals {
+		m.finals[stateId] = true
+	}
+	m.listeners = append([]Listener[S, E, C](nil), s.listeners...)
+	m.interceptors = append([]Interceptor[S, E, C](nil), s.interceptors...)
+	return &m
+}
+
+func (s *stateMachine[S, E, C]) createAndGetState(stateId S) *state[S, E, C] {
+	return s.stateMap.createAndGet(stateId)
 }
 
 var _ StateMachine[int, int, int] = (*stateMachine[int, int, int])(nil)
@@ -1,22 +1,102 @@
 package statemachine
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
 
-var registerMap = make(map[string]any)
+// ErrMachineNotFound 注册表中没有对应 id 的状态机
+var ErrMachineNotFound = NewError("state machine not found")
 
-func registerStateMachine[S, E ID, C any](stateMachine StateMachine[S, E, C]) error {
-	machineId := stateMachine.GetMachineId()
-	if _, ok := registerMap[machineId]; ok {
+// Machine 可以注册到 Registry 的状态机，所有 StateMachine 都实现了该接口
+type Machine interface {
+	GetMachineId() string
+}
+
+// Registry 按 id 保存构建好的状态机，可以并发使用
+type Registry struct {
+	mu       sync.RWMutex
+	machines map[string]Machine
+}
+
+// NewRegistry 创建一个空的注册表，测试中可以使用独立的注册表避免状态机 id 冲突
+func NewRegistry() *Registry {
+	return &Registry{
+		machines: make(map[string]Machine),
+	}
+}
+
+// DefaultRegistry 默认的注册表，Builder 没有指定注册表时使用
+var DefaultRegistry = NewRegistry()
+
+// Register 注册状态机，id 已经存在时返回错误
+func (r *Registry) Register(machine Machine) error {
+	machineId := machine.GetMachineId()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.machines[machineId]; ok {
 		return NewError(fmt.Sprintf("状态机 [%s] 已经构建, 不需要重新构建", machineId))
 	}
-	stateMachine.GetMachineId()
-	registerMap[machineId] = stateMachine
+	r.machines[machineId] = machine
 	return nil
 }
 
+// Replace 注册或替换状态机，返回被替换的状态机，不存在时返回 nil
+func (r *Registry) Replace(machine Machine) Machine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.machines[machine.GetMachineId()]
+	r.machines[machine.GetMachineId()] = machine
+	return old
+}
+
+// Unregister 注销状态机，返回状态机是否存在
+func (r *Registry) Unregister(machineId string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.machines[machineId]
+	delete(r.machines, machineId)
+	return ok
+}
+
+// Lookup 按 id 查找状态机
+func (r *Registry) Lookup(machineId string) (Machine, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	machine, ok := r.machines[machineId]
+	return machine, ok
+}
+
+// List 返回排序后的所有状态机 id
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := make([]string, 0, len(r.machines))
+	for machineId := range r.machines {
+		res = append(res, machineId)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Get 按 id 从注册表中获取指定类型的状态机
+func Get[S, E ID, C any](r *Registry, machineId string) (StateMachine[S, E, C], error) {
+	machine, ok := r.Lookup(machineId)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMachineNotFound, machineId)
+	}
+	v, ok := machine.(StateMachine[S, E, C])
+	if !ok {
+		return nil, NewError(fmt.Sprintf("状态机 [%s] 的类型 %T 不匹配", machineId, machine))
+	}
+	return v, nil
+}
+
 func getStateMachine[S, E ID, C any](machineId string) StateMachine[S, E, C] {
-	if v, ok := registerMap[machineId]; ok {
-		return v.(StateMachine[S, E, C])
+	machine, err := Get[S, E, C](DefaultRegistry, machineId)
+	if err != nil {
+		return nil
 	}
-	return nil
+	return machine
 }
@@ -4,6 +4,10 @@ package statemachine
 type Builder[S, E ID, C any] struct {
 	stateMachine *stateMachine[S, E, C]
 	failCallback FailCallback[S, E, C]
+	validation   *validationOptions[S]
+	registry     *Registry
+	// deferredFailCallback 重新处理延迟事件失败时的回调
+	deferredFailCallback DeferredFailCallback[S, E, C]
 }
 
 // ExternalTransition 外部流转，不同状态之间的流转
@@ -16,29 +20,167 @@ func (b *Builder[S, E, C]) InternalTransition() InternalTransitionBuilder[S, E,
 	return newTransitionBuilder[S, E, C](b.stateMachine, INTERNAL)
 }
 
+// LocalTransition 本地流转，复合状态和它的子状态之间的流转，不会离开和重新进入复合状态
+func (b *Builder[S, E, C]) LocalTransition() LocalTransitionBuilder[S, E, C] {
+	return newTransitionBuilder[S, E, C](b.stateMachine, LOCAL)
+}
+
+// CompositeState 声明复合状态，children 是 parent 的子状态
+// 子状态上没有匹配的流转时，会使用 parent 上定义的流转
+func (b *Builder[S, E, C]) CompositeState(parent S, children ...S) {
+	p := b.stateMachine.createAndGetState(parent)
+	for _, stateId := range children {
+		err := b.stateMachine.createAndGetState(stateId).setParent(p)
+		if err != nil {
+			b.stateMachine.err = err
+			return
+		}
+	}
+}
+
+// InitialState 声明起始状态，用于生成状态图和校验可达性
+func (b *Builder[S, E, C]) InitialState(stateId S) {
+	b.stateMachine.initial = b.stateMachine.createAndGetState(stateId)
+}
+
+// FinalStates 声明终止状态，在终止状态和它的子状态上触发事件会返回 FinalStateError，Build 时终止状态上不能有流转
+func (b *Builder[S, E, C]) FinalStates(stateIds ...S) {
+	for _, stateId := range stateIds {
+		b.stateMachine.createAndGetState(stateId)
+		b.stateMachine.finals[stateId] = true
+	}
+}
+
+// Defer 声明在状态 S 上延迟处理的事件，状态上没有事件对应的流转时，实例会暂存事件，在状态改变后重新处理
+func (b *Builder[S, E, C]) Defer(stateId S, events ...E) {
+	state := b.stateMachine.createAndGetState(stateId)
+	if state.deferred == nil {
+		state.deferred = make(map[E]bool)
+	}
+	for _, event := range events {
+		state.deferred[event] = true
+	}
+}
+
+// OnEntry 设置进入状态时执行的动作，只在外部流转时执行
+func (b *Builder[S, E, C]) OnEntry(stateId S, action Action[S, E, C]) {
+	b.stateMachine.createAndGetState(stateId).entryAction = action
+}
+
+// OnExit 设置离开状态时执行的动作，只在外部流转时执行
+func (b *Builder[S, E, C]) OnExit(stateId S, action Action[S, E, C]) {
+	b.stateMachine.createAndGetState(stateId).exitAction = action
+}
+
+// SetStateAccessor 设置读写实体状态的方法，实例触发事件成功后会通过 setter 把状态写回实体
+func (b *Builder[S, E, C]) SetStateAccessor(getter func(c C) S, setter func(c C, stateId S)) {
+	b.stateMachine.stateGetter = getter
+	b.stateMachine.stateSetter = setter
+}
+
+// SetMaxChainDepth 设置实例处理投递的事件链的最大深度，防止事件无限循环，默认是 10
+func (b *Builder[S, E, C]) SetMaxChainDepth(depth int) {
+	b.stateMachine.maxChainDepth = depth
+}
+
+// SetScheduler 设置超时流转使用的 Scheduler，默认使用系统时间
+func (b *Builder[S, E, C]) SetScheduler(scheduler Scheduler) {
+	b.stateMachine.scheduler = scheduler
+}
+
 // SetFailCallback 设置失败回调
 func (b *Builder[S, E, C]) SetFailCallback(failCallback FailCallback[S, E, C]) {
 	b.failCallback = failCallback
 }
 
-// Build 构建状态机
+// SetDeferredFailCallback 设置重新处理延迟事件失败时的回调，失败的延迟事件会被丢弃，不会影响触发状态改变的事件
+func (b *Builder[S, E, C]) SetDeferredFailCallback(callback DeferredFailCallback[S, E, C]) {
+	b.deferredFailCallback = callback
+}
+
+// AddListener 添加流转监听器，按添加的顺序调用
+func (b *Builder[S, E, C]) AddListener(listener Listener[S, E, C]) {
+	b.stateMachine.listeners = append(b.stateMachine.listeners, listener)
+}
+
+// Use 添加作用于所有流转的拦截器，按添加的顺序由外向内执行
+func (b *Builder[S, E, C]) Use(interceptors ...Interceptor[S, E, C]) {
+	b.stateMachine.interceptors = append(b.stateMachine.interceptors, interceptors...)
+}
+
+// SetStrict 设置严格模式，严格模式下没有可执行的流转时 FireEvent 返回 TransitionNotFoundError 或 GuardRejectedError，
+// 动作返回的错误会被包装成 ActionFailedError
+func (b *Builder[S, E, C]) SetStrict(strict bool) {
+	b.stateMachine.strict = strict
+}
+
+// EnableValidation 开启构建时校验，Build 会返回包含所有问题的 ValidationError
+// 同一个状态和事件上有多个没有条件的流转总是会被校验，其他校验通过 options 开启
+func (b *Builder[S, E, C]) EnableValidation(options ...ValidationOption[S]) {
+	b.validation = &validationOptions[S]{finals: make(map[S]bool)}
+	for _, option := range options {
+		option(b.validation)
+	}
+}
+
+// SetRegistry 设置状态机注册到的注册表，默认是 DefaultRegistry
+func (b *Builder[S, E, C]) SetRegistry(registry *Registry) {
+	b.registry = registry
+}
+
+// Build 构建状态机，并注册到注册表中
 func (b *Builder[S, E, C]) Build(machineId string) (StateMachine[S, E, C], error) {
+	machine, err := b.build(machineId)
+	if err != nil {
+		return nil, err
+	}
+	err = b.registry.Register(machine)
+	if err != nil {
+		return nil, err
+	}
+	return machine, nil
+}
+
+// BuildUnregistered 构建状态机，但是不注册到注册表中，相同 id 的状态机可以构建多次
+// 每次构建都返回新的状态机，构建之后继续修改 Builder 不会影响已经构建的状态机
+func (b *Builder[S, E, C]) BuildUnregistered(machineId string) (StateMachine[S, E, C], error) {
+	return b.build(machineId)
+}
+
+// Rebuild 构建状态机，并原子地替换注册表中相同 id 的状态机，不存在时直接注册
+func (b *Builder[S, E, C]) Rebuild(machineId string) (StateMachine[S, E, C], error) {
+	machine, err := b.build(machineId)
+	if err != nil {
+		return nil, err
+	}
+	b.registry.Replace(machine)
+	return machine, nil
+}
+
+func (b *Builder[S, E, C]) build(machineId string) (*stateMachine[S, E, C], error) {
 	if b.stateMachine.err != nil {
 		return nil, b.stateMachine.err
 	}
-	b.stateMachine.machineId = machineId
-	b.stateMachine.ready = true
-	b.stateMachine.failCallback = b.failCallback
-	err := registerStateMachine[S, E, C](b.stateMachine)
-	if err != nil {
+	if err := b.stateMachine.verify(); err != nil {
 		return nil, err
 	}
-	return b.stateMachine, nil
+	machine := b.stateMachine.clone()
+	machine.machineId = machineId
+	if b.validation != nil {
+		if err := machine.validate(b.validation); err != nil {
+			return nil, err
+		}
+	}
+	machine.ready = true
+	machine.failCallback = b.failCallback
+	machine.deferredFailCallback = b.deferredFailCallback
+	return machine, nil
 }
 
 // NewBuilder 创建一个状态机构建器
 func NewBuilder[S, E ID, C any]() *Builder[S, E, C] {
 	return &Builder[S, E, C]{
 		stateMachine: newStateMachine[S, E, C](make(map[S]*state[S, E, C])),
+		registry:     DefaultRegistry,
 	}
 }
@@ -1,7 +1,11 @@
 package statemachine
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -80,7 +84,7 @@ func performInt(from States, to States, event Events, ctx int) error {
 	return nil
 }
 
-var context = Context1{"creat", 2}
+var testContext = Context1{"creat", 2}
 
 func Test_external(t *testing.T) {
 	builder := NewBuilder[States, Events, Context1]()
@@ -90,7 +94,7 @@ func Test_external(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	target, err := machine.FireEvent(STATE1, EVENT1, context)
+	target, err := machine.FireEvent(STATE1, EVENT1, testContext)
 	if err != nil {
 		t.Error(err)
 	}
@@ -110,7 +114,7 @@ func Test_fail(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	_, err = machine.FireEvent(STATE2, EVENT1, context)
+	_, err = machine.FireEvent(STATE2, EVENT1, testContext)
 	if err != nil {
 		t.Errorf("FireEvent error is = %v, want nil", err)
 	}
@@ -142,7 +146,7 @@ func Test_externals(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	target, err := machine.FireEvent(STATE2, EVENT1, context)
+	target, err := machine.FireEvent(STATE2, EVENT1, testContext)
 	if err != nil {
 		t.Error(err)
 	}
@@ -159,7 +163,7 @@ func Test_internal(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	target, err := machine.FireEvent(STATE1, EVENT1, context)
+	target, err := machine.FireEvent(STATE1, EVENT1, testContext)
 	if target != STATE1 {
 		t.Errorf("FireEvent() = %v, want %v", target, STATE1)
 	}
@@ -167,28 +171,28 @@ func Test_internal(t *testing.T) {
 
 func Test_externalAndInternal(t *testing.T) {
 	machine := buildStateMachine("TestStateMachine-externalAndInternal")
-	target, err := machine.FireEvent(STATE1, EVENT1, context)
+	target, err := machine.FireEvent(STATE1, EVENT1, testContext)
 	if err != nil {
 		t.Error(err)
 	}
 	if target != STATE2 {
 		t.Errorf("FireEvent() = %v, want %v", target, STATE2)
 	}
-	target, err = machine.FireEvent(STATE2, INTERNAL_EVENT, context)
+	target, err = machine.FireEvent(STATE2, INTERNAL_EVENT, testContext)
 	if err != nil {
 		t.Error(err)
 	}
 	if target != STATE2 {
 		t.Errorf("FireEvent() = %v, want %v", target, STATE2)
 	}
-	target, err = machine.FireEvent(STATE2, EVENT2, context)
+	target, err = machine.FireEvent(STATE2, EVENT2, testContext)
 	if err != nil {
 		t.Error(err)
 	}
 	if target != STATE1 {
 		t.Errorf("FireEvent() = %v, want %v", target, STATE1)
 	}
-	target, err = machine.FireEvent(STATE1, EVENT3, context)
+	target, err = machine.FireEvent(STATE1, EVENT3, testContext)
 	if err != nil {
 		t.Error(err)
 	}
@@ -205,7 +209,7 @@ func Test_goroutine(t *testing.T) {
 		go func() {
 			defer group.Done()
 			machine := getStateMachine[States, Events, Context1]("TestStateMachine-goroutine")
-			target, err := machine.FireEvent(STATE1, EVENT1, context)
+			target, err := machine.FireEvent(STATE1, EVENT1, testContext)
 			if err != nil {
 				t.Error(err)
 			}
@@ -218,7 +222,7 @@ func Test_goroutine(t *testing.T) {
 		go func() {
 			defer group.Done()
 			machine := getStateMachine[States, Events, Context1]("TestStateMachine-goroutine")
-			target, err := machine.FireEvent(STATE1, EVENT4, context)
+			target, err := machine.FireEvent(STATE1, EVENT4, testContext)
 			if err != nil {
 				t.Error(err)
 			}
@@ -231,7 +235,7 @@ func Test_goroutine(t *testing.T) {
 		go func() {
 			defer group.Done()
 			machine := getStateMachine[States, Events, Context1]("TestStateMachine-goroutine")
-			target, err := machine.FireEvent(STATE1, EVENT3, context)
+			target, err := machine.FireEvent(STATE1, EVENT3, testContext)
 			if err != nil {
 				t.Error(err)
 			}
@@ -249,6 +253,200 @@ func Test_plantUML(t *testing.T) {
 	fmt.Println(v)
 }
 
+func Test_mermaid(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		WhenNamed("isCreated", conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE1).To(STATE3).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	builder.InternalTransition().Within(STATE2).On(INTERNAL_EVENT).
+		When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE4).To(STATE1).On(EVENT4).
+		When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).
+		When(conditionTrue).Perform(perform)
+	machine, err := builder.Build("TestStateMachine-mermaid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `stateDiagram-v2
+    state STATE4 {
+        STATE2
+        STATE3
+    }
+    STATE1 --> STATE2 : EVENT1 [isCreated]
+    STATE1 --> STATE3 : EVENT1
+    STATE2 --> STATE3 : EVENT2
+    STATE2 --> STATE2 : INTERNAL_EVENT
+    STATE4 --> STATE1 : EVENT4
+`
+	for i := 0; i < 5; i++ {
+		if v := machine.GenerateMermaid(); v != want {
+			t.Fatalf("GenerateMermaid() = %v, want %v", v, want)
+		}
+	}
+}
+
+func Test_mermaidInferred(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(conditionTrue).Perform(perform)
+	machine, err := builder.BuildUnregistered("TestStateMachine-mermaidInferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有声明起始状态和终止状态时和 PlantUML、DOT 一样推断
+	want := `stateDiagram-v2
+    [*] --> STATE1
+    STATE1 --> STATE2 : EVENT1
+    STATE2 --> STATE3 : EVENT2
+    STATE3 --> [*]
+`
+	if v := machine.GenerateMermaid(); v != want {
+		t.Errorf("GenerateMermaid() = %v, want %v", v, want)
+	}
+}
+
+func Test_initialStatesInferred(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2)
+	builder.ExternalTransition().From(STATE1).To(STATE4).On(EVENT1).When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(conditionTrue).Perform(perform)
+	machine, err := builder.BuildUnregistered("TestStateMachine-initialStatesInferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有直接流入的子状态不是起始状态
+	if initials := machine.(*stateMachine[States, Events, Context1]).initialStates(); !reflect.DeepEqual(initials, map[States]bool{STATE1: true}) {
+		t.Errorf("initialStates() = %v, want %v", initials, STATE1)
+	}
+	if v := machine.GenerateMermaid(); strings.Contains(v, "[*] --> STATE2") {
+		t.Errorf("GenerateMermaid() = %v", v)
+	}
+	if v := machine.GenerateDOT(); strings.Contains(v, `"STATE2" [shape=Mdiamond]`) {
+		t.Errorf("GenerateDOT() = %v", v)
+	}
+}
+
+func Test_dot(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		WhenNamed("isCreated", conditionTrue).Perform(perform)
+	builder.InternalTransition().Within(STATE2).On(INTERNAL_EVENT).
+		When(conditionTrue).Perform(perform)
+	builder.LocalTransition().From(STATE4).To(STATE3).On(EVENT3).
+		When(conditionTrue).Perform(perform)
+	machine, err := builder.Build("TestStateMachine-dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph "TestStateMachine-dot" {
+  rankdir=LR;
+  node [shape=ellipse];
+  "STATE1" [shape=Mdiamond];
+  "STATE2";
+  "STATE3" [shape=doublecircle];
+  "STATE4";
+  "STATE1" -> "STATE2" [label="EVENT1 [isCreated]"];
+  "STATE2" -> "STATE2" [label="INTERNAL_EVENT", style=dashed];
+  "STATE4" -> "STATE3" [label="EVENT3", style=dotted];
+}
+`
+	if v := machine.GenerateDOT(); v != want {
+		t.Errorf("GenerateDOT() = %v, want %v", v, want)
+	}
+	want = `digraph "TestStateMachine-dot" {
+  compound=true;
+  rankdir=LR;
+  node [shape=ellipse];
+  "STATE1" [shape=Mdiamond];
+  subgraph "cluster_STATE4" {
+    label="STATE4";
+    "STATE4" [shape=point, style=invis];
+    "STATE2";
+    "STATE3" [shape=doublecircle];
+  }
+  "STATE1" -> "STATE2" [label="EVENT1 [isCreated]"];
+  "STATE2" -> "STATE2" [label="INTERNAL_EVENT", style=dashed];
+  "STATE4" -> "STATE3" [label="EVENT3", style=dotted];
+}
+`
+	if v := machine.GenerateDOT(WithDOTClusters()); v != want {
+		t.Errorf("GenerateDOT(WithDOTClusters()) = %v, want %v", v, want)
+	}
+}
+
+func Test_dotClusterEdges(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.ExternalTransition().From(STATE1).To(STATE4).On(EVENT1).When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE4).To(STATE1).On(EVENT2).When(conditionTrue).Perform(perform)
+	machine, err := builder.Build("TestStateMachine-dotClusterEdges")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只有另一端在 cluster 之外的边才裁剪到 cluster 的边界
+	dot := machine.GenerateDOT(WithDOTClusters())
+	for _, edge := range []string{
+		`"STATE1" -> "STATE4" [label="EVENT1", lhead="cluster_STATE4"];`,
+		`"STATE4" -> "STATE1" [label="EVENT2", ltail="cluster_STATE4"];`,
+	} {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("GenerateDOT(WithDOTClusters()) = %v, want edge %v", dot, edge)
+		}
+	}
+}
+
+func Test_plantUMLOptions(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		WhenNamed("isCreated", conditionTrue).PerformNamed("create", perform)
+	builder.InternalTransition().Within(STATE2).On(INTERNAL_EVENT).
+		When(conditionTrue).PerformNamed("changePrice", perform)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).
+		When(conditionTrue).Perform(perform)
+	machine, err := builder.Build("TestStateMachine-plantUMLOptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `@startuml
+state STATE4 {
+  state STATE2
+  state STATE3
+}
+[*] --> STATE1
+STATE1 --> STATE2 : EVENT1 [isCreated] / create
+STATE2 --> STATE3 : EVENT2
+STATE2 --> STATE2 : INTERNAL_EVENT / changePrice
+STATE3 --> [*]
+@enduml`
+	for i := 0; i < 5; i++ {
+		if v := machine.GeneratePlantUML(); v != want {
+			t.Fatalf("GeneratePlantUML() = %v, want %v", v, want)
+		}
+	}
+	want = `@startuml
+title Order
+skinparam monochrome true
+state STATE4 {
+  state STATE2
+  state STATE3
+}
+[*] --> STATE1
+STATE1 --> STATE2 : EVENT1 [isCreated]
+STATE2 --> STATE3 : EVENT2
+STATE2 --> STATE2 : INTERNAL_EVENT
+STATE3 --> [*]
+@enduml`
+	v := machine.GeneratePlantUML(WithPlantUMLTitle("Order"), WithPlantUMLSkinParam("monochrome", "true"), WithPlantUMLHideActions())
+	if v != want {
+		t.Errorf("GeneratePlantUML() = %v, want %v", v, want)
+	}
+}
+
 func Test_conditionFalse(t *testing.T) {
 	builder := NewBuilder[States, Events, Context1]()
 	builder.ExternalTransition().
@@ -259,7 +457,7 @@ func Test_conditionFalse(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	target, err := machine.FireEvent(STATE1, EVENT1, context)
+	target, err := machine.FireEvent(STATE1, EVENT1, testContext)
 	if err != nil {
 		t.Error(err)
 	}
@@ -338,6 +536,735 @@ func Test_choice(t *testing.T) {
 	}
 }
 
+func Test_compositeState(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).
+		When(conditionTrue).Perform(perform)
+	// 子状态继承复合状态上定义的流转
+	builder.ExternalTransition().From(STATE4).To(STATE1).On(EVENT4).
+		When(conditionTrue).Perform(perform)
+	builder.InternalTransition().Within(STATE4).On(INTERNAL_EVENT).
+		When(conditionTrue).Perform(perform)
+	// 子状态上的流转优先于复合状态
+	builder.ExternalTransition().From(STATE3).To(STATE2).On(EVENT4).
+		When(conditionTrue).Perform(perform)
+	machine, err := builder.Build("TestStateMachine-compositeState")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		from  States
+		event Events
+		want  States
+	}{
+		{STATE2, EVENT4, STATE1},
+		{STATE3, EVENT4, STATE2},
+		{STATE2, INTERNAL_EVENT, STATE2},
+		{STATE1, EVENT4, STATE1},
+	}
+	for _, tt := range tests {
+		target, err := machine.FireEvent(tt.from, tt.event, testContext)
+		if err != nil {
+			t.Error(err)
+		}
+		if target != tt.want {
+			t.Errorf("FireEvent(%v, %v) = %v, want %v", tt.from, tt.event, target, tt.want)
+		}
+	}
+	if !machine.Verify(STATE2, EVENT4) {
+		t.Error("Verify() = false, want true")
+	}
+	uml := machine.GeneratePlantUML()
+	if !strings.Contains(uml, "state STATE4 {\n  state STATE2\n  state STATE3\n}\n") &&
+		!strings.Contains(uml, "state STATE4 {\n  state STATE3\n  state STATE2\n}\n") {
+		t.Errorf("GeneratePlantUML() = %v, want composite state STATE4", uml)
+	}
+}
+
+func Test_compositeStateCycle(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE1, STATE2)
+	builder.CompositeState(STATE2, STATE1)
+	_, err := builder.Build("TestStateMachine-compositeStateCycle")
+	if !IsStateMachineError(err) {
+		t.Errorf("Build err = %v, want StateMachineError", err)
+	}
+}
+
+func Test_entryAndExit(t *testing.T) {
+	var records []string
+	record := func(name string) Action[States, Events, Context1] {
+		return func(from States, to States, event Events, ctx Context1) error {
+			records = append(records, name)
+			return nil
+		}
+	}
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(record("action"))
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).
+		When(conditionTrue).Perform(record("action"))
+	builder.InternalTransition().Within(STATE3).On(INTERNAL_EVENT).
+		When(conditionTrue).Perform(record("action"))
+	builder.ExternalTransition().From(STATE4).To(STATE1).On(EVENT4).
+		When(conditionTrue).Perform(record("action"))
+	for _, stateId := range []States{STATE1, STATE2, STATE3, STATE4} {
+		builder.OnEntry(stateId, record(fmt.Sprintf("entry %v", stateId)))
+		builder.OnExit(stateId, record(fmt.Sprintf("exit %v", stateId)))
+	}
+	machine, err := builder.Build("TestStateMachine-entryAndExit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		from  States
+		event Events
+		want  []string
+	}{
+		{STATE1, EVENT1, []string{"exit STATE1", "action", "entry STATE4", "entry STATE2"}},
+		{STATE2, EVENT2, []string{"exit STATE2", "action", "entry STATE3"}},
+		{STATE3, INTERNAL_EVENT, []string{"action"}},
+		{STATE3, EVENT4, []string{"exit STATE3", "exit STATE4", "action", "entry STATE1"}},
+	}
+	for _, tt := range tests {
+		records = nil
+		if _, err := machine.FireEvent(tt.from, tt.event, testContext); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(records, tt.want) {
+			t.Errorf("FireEvent(%v, %v) actions = %v, want %v", tt.from, tt.event, records, tt.want)
+		}
+	}
+}
+
+func Test_exitError(t *testing.T) {
+	exitErr := NewError("exit failed")
+	performed := false
+	builder := NewBuilder[States, Events, Context1]()
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(func(from States, to States, event Events, ctx Context1) error {
+		performed = true
+		return nil
+	})
+	builder.OnExit(STATE1, func(from States, to States, event Events, ctx Context1) error {
+		return exitErr
+	})
+	machine, err := builder.Build("TestStateMachine-exitError")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = machine.FireEvent(STATE1, EVENT1, testContext)
+	if !errors.Is(err, exitErr) {
+		t.Errorf("FireEvent err = %v, want %v", err, exitErr)
+	}
+	if performed {
+		t.Error("action performed after exit action failed")
+	}
+}
+
+func Test_local(t *testing.T) {
+	var records []string
+	record := func(name string) Action[States, Events, Context1] {
+		return func(from States, to States, event Events, ctx Context1) error {
+			records = append(records, name)
+			return nil
+		}
+	}
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.LocalTransition().From(STATE4).To(STATE3).On(EVENT3).
+		When(conditionTrue).Perform(record("action"))
+	builder.ExternalTransition().From(STATE4).To(STATE3).On(EVENT1).
+		When(conditionTrue).Perform(record("action"))
+	for _, stateId := range []States{STATE2, STATE3, STATE4} {
+		builder.OnEntry(stateId, record(fmt.Sprintf("entry %v", stateId)))
+		builder.OnExit(stateId, record(fmt.Sprintf("exit %v", stateId)))
+	}
+	machine, err := builder.Build("TestStateMachine-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		event Events
+		want  []string
+	}{
+		{EVENT3, []string{"exit STATE2", "action", "entry STATE3"}},
+		{EVENT1, []string{"exit STATE2", "exit STATE4", "action", "entry STATE4", "entry STATE3"}},
+	}
+	for _, tt := range tests {
+		records = nil
+		target, err := machine.FireEvent(STATE2, tt.event, testContext)
+		if err != nil {
+			t.Error(err)
+		}
+		if target != STATE3 {
+			t.Errorf("FireEvent() = %v, want %v", target, STATE3)
+		}
+		if !reflect.DeepEqual(records, tt.want) {
+			t.Errorf("FireEvent(%v) actions = %v, want %v", tt.event, records, tt.want)
+		}
+	}
+	if uml := machine.GeneratePlantUML(); !strings.Contains(uml, "STATE4 -[dashed]-> STATE3 : EVENT3") {
+		t.Errorf("GeneratePlantUML() = %v, want dashed local transition", uml)
+	}
+}
+
+func Test_localWithoutComposite(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.LocalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	_, err := builder.Build("TestStateMachine-localWithoutComposite")
+	if !IsStateMachineError(err) {
+		t.Errorf("Build err = %v, want StateMachineError", err)
+	}
+}
+
+func Test_fireEventContext(t *testing.T) {
+	type key struct{}
+	builder := NewBuilder[States, Events, Context1]()
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		WhenContext(func(ctx context.Context, c Context1) bool {
+			return ctx.Value(key{}) == "ok"
+		}).PerformContext(func(ctx context.Context, from States, to States, event Events, c Context1) error {
+		return ctx.Err()
+	})
+	machine, err := builder.Build("TestStateMachine-fireEventContext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := machine.FireEventContext(context.WithValue(context.Background(), key{}, "ok"), STATE1, EVENT1, testContext)
+	if err != nil {
+		t.Error(err)
+	}
+	if target != STATE2 {
+		t.Errorf("FireEventContext() = %v, want %v", target, STATE2)
+	}
+	target, err = machine.FireEventContext(context.Background(), STATE1, EVENT1, testContext)
+	if err != nil {
+		t.Error(err)
+	}
+	if target != STATE1 {
+		t.Errorf("FireEventContext() = %v, want %v", target, STATE1)
+	}
+}
+
+func Test_fireEventCanceled(t *testing.T) {
+	performed := 0
+	builder := NewBuilder[States, Events, Context1]()
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).PerformContext(func(ctx context.Context, from States, to States, event Events, c Context1) error {
+		performed++
+		return nil
+	})
+	var cancelDuring context.CancelFunc
+	builder.ExternalTransition().From(STATE1).To(STATE3).On(EVENT3).
+		When(conditionTrue).PerformContext(func(ctx context.Context, from States, to States, event Events, c Context1) error {
+		cancelDuring()
+		return nil
+	})
+	machine, err := builder.Build("TestStateMachine-fireEventCanceled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = machine.FireEventContext(ctx, STATE1, EVENT1, testContext)
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("FireEventContext err = %v, want %v", err, ErrCanceled)
+	}
+	if performed != 0 {
+		t.Error("action performed after context canceled")
+	}
+	ctx, cancelDuring = context.WithCancel(context.Background())
+	defer cancelDuring()
+	_, err = machine.FireEventContext(ctx, STATE1, EVENT3, testContext)
+	if !errors.Is(err, ErrCanceled) {
+		t.Errorf("FireEventContext err = %v, want %v", err, ErrCanceled)
+	}
+}
+
+func Test_errorIdentity(t *testing.T) {
+	// 消息相同的错误不是同一个错误
+	err := NewError("transition canceled")
+	if errors.Is(err, ErrCanceled) {
+		t.Errorf("errors.Is(%v, ErrCanceled) = true", err)
+	}
+	if !errors.Is(fmt.Errorf("wrapped: %w", ErrCanceled), ErrCanceled) || !IsStateMachineError(err) {
+		t.Errorf("wrapped ErrCanceled not matched")
+	}
+}
+
+func Test_strict(t *testing.T) {
+	actionErr := errors.New("action failed")
+	builder := NewBuilder[States, Events, Context1]()
+	builder.SetStrict(true)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionFalse).Perform(perform)
+	builder.ExternalTransition().From(STATE1).To(STATE3).On(EVENT3).
+		When(conditionTrue).Perform(func(from States, to States, event Events, ctx Context1) error {
+		return actionErr
+	})
+	machine, err := builder.Build("TestStateMachine-strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := machine.FireEvent(STATE1, EVENT2, testContext)
+	var notFound *TransitionNotFoundError[States, Events]
+	if !errors.As(err, &notFound) || !errors.Is(err, ErrTransitionNotFound) || !IsStateMachineError(err) {
+		t.Errorf("FireEvent err = %v, want TransitionNotFoundError", err)
+	} else if notFound.MachineId != "TestStateMachine-strict" || notFound.State != STATE1 || notFound.Event != EVENT2 {
+		t.Errorf("TransitionNotFoundError = %+v", notFound)
+	}
+	if target != STATE1 {
+		t.Errorf("FireEvent() = %v, want %v", target, STATE1)
+	}
+
+	_, err = machine.FireEvent(STATE1, EVENT1, testContext)
+	var rejected *GuardRejectedError[States, Events]
+	if !errors.As(err, &rejected) || !errors.Is(err, ErrGuardRejected) {
+		t.Errorf("FireEvent err = %v, want GuardRejectedError", err)
+	}
+
+	_, err = machine.FireEvent(STATE1, EVENT3, testContext)
+	var failed *ActionFailedError[States, Events]
+	if !errors.As(err, &failed) || !errors.Is(err, ErrActionFailed) || !errors.Is(err, actionErr) {
+		t.Errorf("FireEvent err = %v, want ActionFailedError", err)
+	} else if failed.From != STATE1 || failed.To != STATE3 {
+		t.Errorf("ActionFailedError = %+v", failed)
+	}
+}
+
+type recordListener struct {
+	NopListener[States, Events, Context1]
+	records []string
+}
+
+func (l *recordListener) BeforeTransition(info TransitionInfo[States, Events, Context1]) {
+	l.records = append(l.records, fmt.Sprintf("before %s %v-[%v, %v]->%v", info.MachineId, info.From, info.Event, info.Type, info.To))
+}
+
+func (l *recordListener) AfterTransition(info TransitionInfo[States, Events, Context1]) {
+	l.records = append(l.records, fmt.Sprintf("after %v-[%v]->%v", info.From, info.Event, info.To))
+}
+
+func (l *recordListener) TransitionFailed(info TransitionInfo[States, Events, Context1], err error) {
+	l.records = append(l.records, fmt.Sprintf("failed %v-[%v]->%v: %v", info.From, info.Event, info.To, err))
+}
+
+func Test_listener(t *testing.T) {
+	listener := &recordListener{}
+	builder := NewBuilder[States, Events, Context1]()
+	builder.AddListener(listener)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	builder.InternalTransition().Within(STATE2).On(INTERNAL_EVENT).
+		When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT3).
+		When(conditionTrue).Perform(func(from States, to States, event Events, ctx Context1) error {
+		return NewError("boom")
+	})
+	machine, err := builder.Build("TestStateMachine-listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = machine.FireEvent(STATE1, EVENT1, testContext)
+	_, _ = machine.FireEvent(STATE2, INTERNAL_EVENT, testContext)
+	_, _ = machine.FireEvent(STATE2, EVENT3, testContext)
+	_, _ = machine.FireEvent(STATE3, EVENT1, testContext)
+	want := []string{
+		"before TestStateMachine-listener STATE1-[EVENT1, EXTERNAL]->STATE2",
+		"after STATE1-[EVENT1]->STATE2",
+		"before TestStateMachine-listener STATE2-[INTERNAL_EVENT, INTERNAL]->STATE2",
+		"after STATE2-[INTERNAL_EVENT]->STATE2",
+		"before TestStateMachine-listener STATE2-[EVENT3, EXTERNAL]->STATE3",
+		"failed STATE2-[EVENT3]->STATE3: boom",
+	}
+	if !reflect.DeepEqual(listener.records, want) {
+		t.Errorf("listener records = %v, want %v", listener.records, want)
+	}
+}
+
+func Test_noTransitionListener(t *testing.T) {
+	var infos []TransitionInfo[States, Events, Context1]
+	builder := NewBuilder[States, Events, Context1]()
+	builder.AddListener(noTransitionListener(func(info TransitionInfo[States, Events, Context1]) {
+		infos = append(infos, info)
+	}))
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	machine, err := builder.Build("TestStateMachine-noTransitionListener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = machine.FireEvent(STATE2, EVENT1, testContext)
+	want := []TransitionInfo[States, Events, Context1]{
+		{MachineId: "TestStateMachine-noTransitionListener", From: STATE2, To: STATE2, Event: EVENT1, Context: testContext},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Errorf("NoTransition infos = %v, want %v", infos, want)
+	}
+}
+
+type noTransitionListener func(info TransitionInfo[States, Events, Context1])
+
+func (f noTransitionListener) BeforeTransition(TransitionInfo[States, Events, Context1]) {}
+
+func (f noTransitionListener) AfterTransition(TransitionInfo[States, Events, Context1]) {}
+
+func (f noTransitionListener) TransitionFailed(TransitionInfo[States, Events, Context1], error) {}
+
+func (f noTransitionListener) NoTransition(info TransitionInfo[States, Events, Context1]) {
+	f(info)
+}
+
+func Test_interceptor(t *testing.T) {
+	var records []string
+	record := func(name string) Interceptor[States, Events, Context1] {
+		return func(next TransitHandler[States, Events, Context1]) TransitHandler[States, Events, Context1] {
+			return func(ctx context.Context, info TransitionInfo[States, Events, Context1]) (States, error) {
+				records = append(records, "before "+name)
+				to, err := next(ctx, info)
+				records = append(records, fmt.Sprintf("after %s %v", name, to))
+				return to, err
+			}
+		}
+	}
+	builder := NewBuilder[States, Events, Context1]()
+	builder.Use(record("machine1"), record("machine2"))
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Use(record("transition")).Perform(func(from States, to States, event Events, ctx Context1) error {
+		records = append(records, "action")
+		return nil
+	})
+	machine, err := builder.Build("TestStateMachine-interceptor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = machine.FireEvent(STATE1, EVENT1, testContext); err != nil {
+		t.Error(err)
+	}
+	want := []string{
+		"before machine1", "before machine2", "before transition", "action",
+		"after transition STATE2", "after machine2 STATE2", "after machine1 STATE2",
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("interceptor records = %v, want %v", records, want)
+	}
+}
+
+func Test_interceptorShortCircuit(t *testing.T) {
+	denied := NewError("denied")
+	performed := false
+	builder := NewBuilder[States, Events, Context1]()
+	builder.Use(func(next TransitHandler[States, Events, Context1]) TransitHandler[States, Events, Context1] {
+		return func(ctx context.Context, info TransitionInfo[States, Events, Context1]) (States, error) {
+			switch info.Event {
+			case EVENT3:
+				return info.From, denied
+			case EVENT2:
+				// 跳过流转，返回的零值状态会被忽略
+				var zero States
+				return zero, nil
+			}
+			to, err := next(ctx, info)
+			if err != nil {
+				return to, fmt.Errorf("intercepted: %w", err)
+			}
+			return to, nil
+		}
+	})
+	builder.ExternalTransition().From(STATE1).To(STATE3).On(EVENT3).
+		When(conditionTrue).Perform(func(from States, to States, event Events, ctx Context1) error {
+		performed = true
+		return nil
+	})
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(func(from States, to States, event Events, ctx Context1) error {
+		return NewError("boom")
+	})
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT2).When(conditionTrue).Perform(perform)
+	machine, err := builder.Build("TestStateMachine-interceptorShortCircuit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = machine.FireEvent(STATE1, EVENT3, testContext); !errors.Is(err, denied) {
+		t.Errorf("FireEvent err = %v, want %v", err, denied)
+	}
+	if performed {
+		t.Error("action performed after interceptor short-circuited")
+	}
+	if _, err = machine.FireEvent(STATE1, EVENT1, testContext); err == nil || err.Error() != "intercepted: boom" {
+		t.Errorf("FireEvent err = %v, want intercepted: boom", err)
+	}
+	instance := machine.NewInstance(STATE1, testContext)
+	if to, err := instance.Fire(EVENT2, testContext); to != STATE1 || err != nil || instance.Current() != STATE1 {
+		t.Errorf("Fire(EVENT2) = %v, %v, want %v", to, err, STATE1)
+	}
+}
+
+func Test_validation(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.EnableValidation(ValidateReachableFrom[States](STATE1), ValidateFinalStates[States](STATE3), ValidateRequireAction[States]())
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(nil).Perform(perform)
+	builder.ExternalTransition().From(STATE1).To(STATE3).On(EVENT1).
+		When(nil).Perform(perform)
+	builder.ExternalTransition().From(STATE4).To(STATE3).On(EVENT4).
+		When(conditionTrue).Perform(nil)
+	_, err := builder.Build("TestStateMachine-validation")
+	var validationErr *ValidationError[States, Events]
+	if !errors.As(err, &validationErr) || !errors.Is(err, ErrValidation) || !IsStateMachineError(err) {
+		t.Fatalf("Build err = %v, want ValidationError", err)
+	}
+	var got []string
+	for _, problem := range validationErr.Problems {
+		got = append(got, fmt.Sprintf("%v %v", problem.Kind, problem.State))
+	}
+	want := []string{
+		"UnreachableState STATE4",
+		"DeadEndState STATE2",
+		"MissingAction STATE4",
+		"AmbiguousTransition STATE1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Problems = %v, want %v", got, want)
+	}
+}
+
+func Test_validationPassed(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.EnableValidation(ValidateReachableFrom[States](STATE1), ValidateFinalStates[States](STATE3))
+	builder.CompositeState(STATE4, STATE2)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE4).To(STATE3).On(EVENT4).
+		When(conditionTrue).Perform(perform)
+	if _, err := builder.Build("TestStateMachine-validationPassed"); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_initialAndFinal(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.InitialState(STATE1)
+	builder.FinalStates(STATE3)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).
+		When(conditionTrue).Perform(perform)
+	machine, err := builder.Build("TestStateMachine-initialAndFinal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initial, ok := machine.InitialState(); !ok || initial != STATE1 {
+		t.Errorf("InitialState() = %v, %v, want %v, true", initial, ok, STATE1)
+	}
+	if !machine.IsFinal(STATE3) || machine.IsFinal(STATE2) {
+		t.Error("IsFinal() returns wrong result")
+	}
+	if machine.Verify(STATE3, EVENT3) {
+		t.Error("Verify() = true, want false")
+	}
+	target, err := machine.FireEvent(STATE3, EVENT3, testContext)
+	var finalErr *FinalStateError[States, Events]
+	if !errors.As(err, &finalErr) || !errors.Is(err, ErrFinalState) || finalErr.State != STATE3 {
+		t.Errorf("FireEvent err = %v, want FinalStateError", err)
+	}
+	if target != STATE3 {
+		t.Errorf("FireEvent() = %v, want %v", target, STATE3)
+	}
+	want := `stateDiagram-v2
+    [*] --> STATE1
+    STATE1 --> STATE2 : EVENT1
+    STATE2 --> STATE3 : EVENT2
+    STATE3 --> [*]
+`
+	if v := machine.GenerateMermaid(); v != want {
+		t.Errorf("GenerateMermaid() = %v, want %v", v, want)
+	}
+
+	// 终止状态上的流转永远不会执行，构建时返回错误
+	builder.ExternalTransition().From(STATE3).To(STATE1).On(EVENT3).
+		When(conditionTrue).Perform(perform)
+	if _, err = builder.BuildUnregistered("TestStateMachine-initialAndFinal"); !IsStateMachineError(err) {
+		t.Errorf("Build() err = %v, want state machine error", err)
+	}
+}
+
+func Test_finalCompositeState(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.CompositeState(STATE4, STATE2, STATE3)
+	builder.FinalStates(STATE4)
+	builder.ExternalTransition().From(STATE1).To(STATE3).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	machine, err := builder.BuildUnregistered("TestStateMachine-finalCompositeState")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 终止的复合状态的子状态也不能触发事件
+	if !machine.IsFinal(STATE3) || machine.Verify(STATE3, EVENT1) {
+		t.Errorf("IsFinal(%v) = %v, Verify = %v", STATE3, machine.IsFinal(STATE3), machine.Verify(STATE3, EVENT1))
+	}
+	if target, err := machine.FireEvent(STATE3, EVENT1, testContext); target != STATE3 || !errors.Is(err, ErrFinalState) {
+		t.Errorf("FireEvent() = %v, %v, want %v, %v", target, err, STATE3, ErrFinalState)
+	}
+
+	builder.ExternalTransition().From(STATE3).To(STATE1).On(EVENT3).
+		When(conditionTrue).Perform(perform)
+	if _, err = builder.BuildUnregistered("TestStateMachine-finalCompositeState"); !IsStateMachineError(err) {
+		t.Errorf("Build() err = %v, want state machine error", err)
+	}
+}
+
+func Test_validationDeclaredStates(t *testing.T) {
+	builder := NewBuilder[States, Events, Context1]()
+	builder.EnableValidation()
+	builder.InitialState(STATE1)
+	builder.FinalStates(STATE2)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+		When(conditionTrue).Perform(perform)
+	builder.ExternalTransition().From(STATE3).To(STATE4).On(EVENT3).
+		When(conditionTrue).Perform(perform)
+	_, err := builder.Build("TestStateMachine-validationDeclaredStates")
+	var validationErr *ValidationError[States, Events]
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Build err = %v, want ValidationError", err)
+	}
+	var got []string
+	for _, problem := range validationErr.Problems {
+		got = append(got, fmt.Sprintf("%v %v", problem.Kind, problem.State))
+	}
+	want := []string{"UnreachableState STATE3", "UnreachableState STATE4", "DeadEndState STATE4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Problems = %v, want %v", got, want)
+	}
+}
+
+func Test_registry(t *testing.T) {
+	registry := NewRegistry()
+	group := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		group.Add(1)
+		go func(i int) {
+			defer group.Done()
+			builder := NewBuilder[States, Events, Context1]()
+			builder.SetRegistry(registry)
+			builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
+				When(conditionTrue).Perform(perform)
+			if _, err := builder.Build(fmt.Sprintf("machine-%d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	group.Wait()
+	if got := registry.List(); len(got) != 10 || got[0] != "machine-0" {
+		t.Errorf("List() = %v, want 10 machines", got)
+	}
+	if _, ok := DefaultRegistry.Lookup("machine-0"); ok {
+		t.Error("machine registered to DefaultRegistry")
+	}
+	machine, err := Get[States, Events, Context1](registry, "machine-1")
+	if err != nil || machine.GetMachineId() != "machine-1" {
+		t.Errorf("Get() = %v, %v", machine, err)
+	}
+	if _, err = Get[States, Events, int](registry, "machine-1"); !IsStateMachineError(err) {
+		t.Errorf("Get() err = %v, want StateMachineError", err)
+	}
+	if _, err = Get[States, Events, Context1](registry, "machine-x"); !errors.Is(err, ErrMachineNotFound) {
+		t.Errorf("Get() err = %v, want %v", err, ErrMachineNotFound)
+	}
+	if old := registry.Replace(machine); old != machine {
+		t.Errorf("Replace() = %v, want %v", old, machine)
+	}
+	if !registry.Unregister("machine-1") || registry.Unregister("machine-1") {
+		t.Error("Unregister() returns wrong result")
+	}
+	if err = registry.Register(machine); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_buildUnregistered(t *testing.T) {
+	for _, tt := range []struct {
+		target States
+	}{{STATE2}, {STATE3}} {
+		builder := NewBuilder[States, Events, Context1]()
+		builder.ExternalTransition().From(STATE1).To(tt.target).On(EVENT1).
+			When(conditionTrue).Perform(perform)
+		machine, err := builder.BuildUnregistered("TestStateMachine-buildUnregistered")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target, _ := machine.FireEvent(STATE1, EVENT1, testContext); target != tt.target {
+			t.Errorf("FireEvent() = %v, want %v", target, tt.target)
+		}
+	}
+	if _, ok := DefaultRegistry.Lookup("TestStateMachine-buildUnregistered"); ok {
+		t.Error("BuildUnregistered() registered the machine")
+	}
+}
+
+func Test_rebuild(t *testing.T) {
+	registry := NewRegistry()
+	for _, target := range []States{STATE2, STATE3} {
+		builder := NewBuilder[States, Events, Context1]()
+		builder.SetRegistry(registry)
+		builder.ExternalTransition().From(STATE1).To(target).On(EVENT1).
+			When(conditionTrue).Perform(perform)
+		if _, err := builder.Rebuild("TestStateMachine-rebuild"); err != nil {
+			t.Fatal(err)
+		}
+		machine, err := Get[States, Events, Context1](registry, "TestStateMachine-rebuild")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := machine.FireEvent(STATE1, EVENT1, testContext); got != target {
+			t.Errorf("FireEvent() = %v, want %v", got, target)
+		}
+	}
+}
+
+func Test_buildIsolated(t *testing.T) {
+	registry := NewRegistry()
+	builder := NewBuilder[States, Events, Context1]()
+	builder.SetRegistry(registry)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(conditionTrue).Perform(perform)
+	first, err := builder.BuildUnregistered("TestStateMachine-buildIsolated1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := builder.BuildUnregistered("TestStateMachine-buildIsolated2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.GetMachineId() != "TestStateMachine-buildIsolated1" || second.GetMachineId() != "TestStateMachine-buildIsolated2" {
+		t.Errorf("GetMachineId() = %v, %v", first.GetMachineId(), second.GetMachineId())
+	}
+	registered, err := builder.Build("TestStateMachine-buildIsolated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 构建之后继续修改 Builder 不会影响已经构建的状态机，需要通过 Rebuild 替换
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(conditionTrue).Perform(perform)
+	for _, machine := range []StateMachine[States, Events, Context1]{first, second, registered} {
+		if machine.Verify(STATE2, EVENT2) {
+			t.Errorf("%s: Verify() = true, want false", machine.GetMachineId())
+		}
+	}
+	if _, err = builder.Rebuild("TestStateMachine-buildIsolated"); err != nil {
+		t.Fatal(err)
+	}
+	machine, err := Get[States, Events, Context1](registry, "TestStateMachine-buildIsolated")
+	if err != nil || !machine.Verify(STATE2, EVENT2) {
+		t.Errorf("Get() = %v, Verify() = false", err)
+	}
+}
+
 func buildStateMachine(machineId string) StateMachine[States, Events, Context1] {
 	builder := NewBuilder[States, Events, Context1]()
 	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).
@@ -0,0 +1,117 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrStateNotFound StateStore 中没有 key 对应的状态
+	ErrStateNotFound = NewError("state not found")
+	// ErrVersionConflict 保存状态时版本号已经被其他人修改
+	ErrVersionConflict = NewError("state version conflict")
+)
+
+// StateStore 保存实体的状态和版本号，用于乐观并发控制
+type StateStore[S ID] interface {
+	// Load 读取 key 对应的状态和版本号，不存在时返回 ErrStateNotFound
+	Load(ctx context.Context, key string) (stateId S, version int64, err error)
+	// Save 当 key 的版本号仍然是 version 时保存状态并把版本号加一，否则返回 ErrVersionConflict
+	// version 为 0 表示 key 还不存在
+	Save(ctx context.Context, key string, stateId S, version int64) error
+}
+
+// Runner 使用 StateStore 读取状态，通过实例触发事件，再保存状态
+type Runner[S, E ID, C any] struct {
+	machine StateMachine[S, E, C]
+	store   StateStore[S]
+	// journal 设置到每次触发事件创建的实例上，encode 用来序列化上下文
+	journal Journal[S, E]
+	encode  func(c C) ([]byte, error)
+}
+
+// NewRunner 创建一个使用 store 保存状态的 Runner
+func NewRunner[S, E ID, C any](machine StateMachine[S, E, C], store StateStore[S]) *Runner[S, E, C] {
+	return &Runner[S, E, C]{
+		machine: machine,
+		store:   store,
+	}
+}
+
+// SetJournal 设置日志，之后每次 Fire 创建的实例都会把处理的事件追加到 journal 中，见 Instance.SetJournal
+func (r *Runner[S, E, C]) SetJournal(journal Journal[S, E], encode func(c C) ([]byte, error)) {
+	r.journal = journal
+	r.encode = encode
+}
+
+// Fire 读取 key 的当前状态，用它创建实例并触发事件 E，只有在版本号没有改变时才保存新的状态，否则返回 ErrVersionConflict
+// key 不存在时从状态机声明的起始状态开始，动作可以投递后续事件，设置了状态访问器时会写回 c
+// 实例只在一次 Fire 中存在，事件链处理完之后仍然等待的延迟事件和超时事件会被丢弃
+// 投递的事件处理失败时，已经完成的流转仍然会被保存，同时返回错误
+// 动作在保存之前执行，发生冲突时调用方需要自己处理动作的副作用
+func (r *Runner[S, E, C]) Fire(ctx context.Context, key string, event E, c C) (S, error) {
+	from, version, err := r.store.Load(ctx, key)
+	if errors.Is(err, ErrStateNotFound) {
+		initial, ok := r.machine.InitialState()
+		if !ok {
+			return from, err
+		}
+		from, version = initial, 0
+	} else if err != nil {
+		return from, err
+	}
+	instance := r.machine.NewInstance(from, c)
+	defer instance.Stop()
+	if r.journal != nil {
+		instance.SetJournal(r.journal, r.encode)
+	}
+	to, err := instance.FireContext(ctx, event, c)
+	if to == from && (version != 0 || err != nil) {
+		return to, err
+	}
+	if saveErr := r.store.Save(ctx, key, to, version); saveErr != nil {
+		return from, saveErr
+	}
+	return to, err
+}
+
+// MemoryStateStore 保存在内存中的 StateStore，可以并发使用
+type MemoryStateStore[S ID] struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord[S]
+}
+
+type memoryRecord[S ID] struct {
+	stateId S
+	version int64
+}
+
+// NewMemoryStateStore 创建一个空的 MemoryStateStore
+func NewMemoryStateStore[S ID]() *MemoryStateStore[S] {
+	return &MemoryStateStore[S]{
+		records: make(map[string]memoryRecord[S]),
+	}
+}
+
+func (m *MemoryStateStore[S]) Load(_ context.Context, key string) (r S, version int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.records[key]
+	if !ok {
+		return r, 0, ErrStateNotFound
+	}
+	return record.stateId, record.version, nil
+}
+
+func (m *MemoryStateStore[S]) Save(_ context.Context, key string, stateId S, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.records[key].version != version {
+		return ErrVersionConflict
+	}
+	m.records[key] = memoryRecord[S]{stateId: stateId, version: version + 1}
+	return nil
+}
+
+var _ StateStore[int] = (*MemoryStateStore[int])(nil)
@@ -0,0 +1,114 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_memoryStateStore(t *testing.T) {
+	testStateStore(t, NewMemoryStateStore[States]())
+}
+
+// testStateStore 测试 StateStore 的实现是否满足乐观并发控制的约定
+func testStateStore(t *testing.T, store StateStore[States]) {
+	ctx := context.Background()
+	if _, _, err := store.Load(ctx, "order-1"); !errors.Is(err, ErrStateNotFound) {
+		t.Errorf("Load() err = %v, want %v", err, ErrStateNotFound)
+	}
+	if err := store.Save(ctx, "order-1", STATE1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(ctx, "order-1", STATE2, 0); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Save() err = %v, want %v", err, ErrVersionConflict)
+	}
+	stateId, version, err := store.Load(ctx, "order-1")
+	if err != nil || stateId != STATE1 || version != 1 {
+		t.Errorf("Load() = %v, %v, %v, want %v, 1, nil", stateId, version, err, STATE1)
+	}
+	if err = store.Save(ctx, "order-1", STATE2, 1); err != nil {
+		t.Error(err)
+	}
+	if err = store.Save(ctx, "order-1", STATE3, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Save() err = %v, want %v", err, ErrVersionConflict)
+	}
+}
+
+func Test_runner(t *testing.T) {
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.InitialState(STATE1)
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).Perform(nil)
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(nil).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var store StateStore[States] = NewMemoryStateStore[States]()
+	runner := NewRunner(machine, store)
+	target, err := runner.Fire(ctx, "order-1", EVENT1, &Entity{})
+	if err != nil || target != STATE2 {
+		t.Errorf("Fire() = %v, %v, want %v", target, err, STATE2)
+	}
+	if stateId, version, _ := store.Load(ctx, "order-1"); stateId != STATE2 || version != 1 {
+		t.Errorf("Load() = %v, %v, want %v, 1", stateId, version, STATE2)
+	}
+
+	// 在读取和保存之间被其他人修改，返回冲突并且状态不变
+	conflict := NewRunner[States, Events, *Entity](machine, &racingStore{StateStore: store})
+	if _, err = conflict.Fire(ctx, "order-1", EVENT2, &Entity{}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Fire() err = %v, want %v", err, ErrVersionConflict)
+	}
+	if stateId, _, _ := store.Load(ctx, "order-1"); stateId != STATE2 {
+		t.Errorf("Load() = %v, want %v", stateId, STATE2)
+	}
+}
+
+func Test_runnerInstance(t *testing.T) {
+	builder := NewBuilder[States, Events, *Entity]()
+	builder.InitialState(STATE1)
+	builder.SetStateAccessor(func(c *Entity) States {
+		return c.Status
+	}, func(c *Entity, stateId States) {
+		c.Status = stateId
+	})
+	builder.ExternalTransition().From(STATE1).To(STATE2).On(EVENT1).When(nil).
+		PerformPost(func(ctx context.Context, post Poster[Events], from States, to States, event Events, c *Entity) error {
+			return post(EVENT2)
+		})
+	builder.ExternalTransition().From(STATE2).To(STATE3).On(EVENT2).When(nil).Perform(nil)
+	machine, err := builder.BuildUnregistered("TestStateMachine-runnerInstance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	store := NewMemoryStateStore[States]()
+	journal := NewMemoryJournal[States, Events]()
+	runner := NewRunner[States, Events, *Entity](machine, store)
+	runner.SetJournal(journal, nil)
+	// 通过实例触发，投递的事件、日志和状态访问器都会生效
+	entity := &Entity{Status: STATE1}
+	target, err := runner.Fire(ctx, "order-1", EVENT1, entity)
+	if err != nil || target != STATE3 || entity.Status != STATE3 {
+		t.Errorf("Fire() = %v, %v, entity = %v, want %v", target, err, entity.Status, STATE3)
+	}
+	if stateId, version, _ := store.Load(ctx, "order-1"); stateId != STATE3 || version != 1 {
+		t.Errorf("Load() = %v, %v, want %v, 1", stateId, version, STATE3)
+	}
+	if entries := journal.Entries(); len(entries) != 2 || entries[1].To != STATE3 {
+		t.Errorf("Entries() = %v", entries)
+	}
+}
+
+// racingStore 在读取之后模拟其他人修改了状态
+type racingStore struct {
+	StateStore[States]
+}
+
+func (s *racingStore) Load(ctx context.Context, key string) (States, int64, error) {
+	stateId, version, err := s.StateStore.Load(ctx, key)
+	if err != nil {
+		return stateId, version, err
+	}
+	return stateId, version, s.StateStore.Save(ctx, key, stateId, version)
+}
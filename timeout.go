@@ -0,0 +1,108 @@
+package statemachine
+
+import (
+	"context"
+	"time"
+)
+
+// timeout 在状态上停留 after 之后触发事件 event
+type timeout[E ID] struct {
+	after time.Duration
+	event E
+}
+
+// pendingTimer 实例上等待触发的超时事件，state 是声明超时流转的状态，离开这个状态时定时器会被停止
+type pendingTimer[S, E ID] struct {
+	state    S
+	event    E
+	deadline time.Time
+	timer    Timer
+}
+
+// scheduleTimers 停止所有定时器，为当前状态和父状态上的超时流转创建定时器，调用时必须持有实例锁
+func (i *Instance[S, E, C]) scheduleTimers() {
+	i.stopTimers()
+	for st := i.machine.stateMap.get(i.current); st != nil; st = st.parent {
+		i.enterTimers(st)
+	}
+}
+
+// updateTimers 停止离开的状态上的定时器，为进入的状态创建定时器，没有离开的父状态上的定时器继续计时，调用时必须持有实例锁
+func (i *Instance[S, E, C]) updateTimers(exits, entries []*state[S, E, C]) {
+	exited := make(map[S]bool, len(exits))
+	for _, st := range exits {
+		exited[st.id] = true
+	}
+	timers := i.timers[:0]
+	for _, pending := range i.timers {
+		if exited[pending.state] {
+			pending.timer.Stop()
+		} else {
+			timers = append(timers, pending)
+		}
+	}
+	i.timers = timers
+	for _, st := range entries {
+		i.enterTimers(st)
+	}
+}
+
+// transitTimers 根据执行的流转更新定时器，外部的自身流转会离开并重新进入状态，所以会重新计时
+func (i *Instance[S, E, C]) transitTimers(from, to S, transition *Transition[S, E, C]) {
+	switch {
+	case transition != nil && transition.ty == INTERNAL && to == from:
+	case transition != nil && transition.ty != INTERNAL && to == transition.target.id:
+		i.updateTimers(transition.exitAndEntryStates(i.machine.stateMap.get(from)))
+	case to != from:
+		// 拦截器改变了目标状态，无法知道离开了哪些状态
+		i.scheduleTimers()
+	}
+}
+
+func (i *Instance[S, E, C]) enterTimers(st *state[S, E, C]) {
+	for _, t := range st.timeouts {
+		i.startTimer(st.id, t.event, t.after)
+	}
+}
+
+func (i *Instance[S, E, C]) startTimer(stateId S, event E, after time.Duration) {
+	scheduler := i.machine.scheduler
+	pending := &pendingTimer[S, E]{state: stateId, event: event, deadline: scheduler.Now().Add(after)}
+	pending.timer = scheduler.AfterFunc(after, func() {
+		i.onTimeout(pending)
+	})
+	i.timers = append(i.timers, pending)
+}
+
+func (i *Instance[S, E, C]) stopTimers() {
+	for _, pending := range i.timers {
+		pending.timer.Stop()
+	}
+	i.timers = nil
+}
+
+// onTimeout 定时器到期时在实例上触发超时事件，已经被停止的定时器会被忽略
+func (i *Instance[S, E, C]) onTimeout(pending *pendingTimer[S, E]) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	found := false
+	for k, p := range i.timers {
+		if p == pending {
+			i.timers = append(i.timers[:k], i.timers[k+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	// 错误已经通过 FailCallback 和 Listener 通知，这里不再处理
+	_, _ = i.fireLocked(context.Background(), pending.event, i.ctx)
+}
+
+// Stop 停止实例上所有等待触发的超时事件
+func (i *Instance[S, E, C]) Stop() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.stopTimers()
+}
@@ -1,6 +1,10 @@
 package statemachine
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 type TransitionType int
 
@@ -27,25 +31,108 @@ type Transition[S, E ID, C any] struct {
 	target    *state[S, E, C]
 	event     E
 	ty        TransitionType
-	condition Condition[C]
-	action    Action[S, E, C]
+	condition ContextCondition[C]
+	action    ContextAction[S, E, C]
+	// conditionName 条件的名字，用于生成状态图
+	conditionName string
+	// actionName 动作的名字，用于生成状态图
+	actionName string
+	// interceptors 只作用于当前流转的拦截器，在状态机的拦截器之后执行
+	interceptors []Interceptor[S, E, C]
+	// after 通过 After 声明的超时时间，为 0 时不是超时流转
+	after time.Duration
 }
 
-func (t *Transition[S, E, C]) transit(ctx C, checkCondition bool) (*state[S, E, C], error) {
+// transit 从 current 状态执行流转，current 可能是 t.source 的子状态（继承了复合状态的流转）
+// 外部流转按 退出动作 -> 流转动作 -> 进入动作 的顺序执行，任何一步出错或 ctx 被取消都会中止流转
+func (t *Transition[S, E, C]) transit(ctx context.Context, current *state[S, E, C], c C, checkCondition bool) (*state[S, E, C], error) {
 	err := t.verify()
 	if err != nil {
 		return nil, err
 	}
-	if !checkCondition || t.condition == nil || t.condition(ctx) {
-		if t.action != nil {
-			err = t.action(t.source.id, t.target.id, t.event, ctx)
-			if err != nil {
-				return nil, err
+	target := t.target
+	// 内部流转不改变状态，子状态继承的内部流转停留在子状态上
+	if t.ty == INTERNAL {
+		target = current
+	}
+	if checkCondition && t.condition != nil && !t.condition(ctx, c) {
+		return current, nil
+	}
+	if err = canceled(ctx); err != nil {
+		return nil, err
+	}
+	exits, entries := t.exitAndEntryStates(current)
+	for _, st := range exits {
+		if st.exitAction != nil {
+			if err = st.exitAction(current.id, target.id, t.event, c); err != nil {
+				return nil, &actionError{err: err}
 			}
 		}
-		return t.target, nil
 	}
-	return t.source, nil
+	if t.action != nil {
+		if err = t.action(ctx, current.id, target.id, t.event, c); err != nil {
+			return nil, &actionError{err: err}
+		}
+	}
+	// 动作执行期间 ctx 被取消，不再推进状态
+	if err = canceled(ctx); err != nil {
+		return nil, err
+	}
+	for _, st := range entries {
+		if st.entryAction != nil {
+			if err = st.entryAction(current.id, target.id, t.event, c); err != nil {
+				return nil, &actionError{err: err}
+			}
+		}
+	}
+	return target, nil
+}
+
+// actionError 标记由动作返回的错误，用来和流转本身的错误区分
+type actionError struct {
+	err error
+}
+
+func (e *actionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *actionError) Unwrap() error {
+	return e.err
+}
+
+// exitAndEntryStates 计算流转需要退出的状态（由内向外）和需要进入的状态（由外向内）
+func (t *Transition[S, E, C]) exitAndEntryStates(current *state[S, E, C]) (exits, entries []*state[S, E, C]) {
+	if t.ty == INTERNAL {
+		return nil, nil
+	}
+	domain := t.domain()
+	for st := current; st != nil && st != domain; st = st.parent {
+		exits = append(exits, st)
+	}
+	for st := t.target; st != nil && st != domain; st = st.parent {
+		entries = append([]*state[S, E, C]{st}, entries...)
+	}
+	return exits, entries
+}
+
+// domain 返回流转过程中不会被退出的最内层状态，nil 表示所有状态都会被退出
+func (t *Transition[S, E, C]) domain() *state[S, E, C] {
+	// 本地流转不会退出作为源状态或目标状态的复合状态
+	if t.ty == LOCAL {
+		if t.target.isDescendantOf(t.source) {
+			return t.source
+		}
+		if t.source.isDescendantOf(t.target) {
+			return t.target
+		}
+	}
+	domain := t.source.commonAncestor(t.target)
+	// 外部流转会退出并重新进入源状态或目标状态所在的复合状态
+	if domain == t.source || domain == t.target {
+		domain = domain.parent
+	}
+	return domain
 }
 
 func (t *Transition[S, E, C]) verify() error {
@@ -53,6 +140,10 @@ func (t *Transition[S, E, C]) verify() error {
 	if t.ty == INTERNAL && t.source != t.target {
 		return NewError(fmt.Sprintf("Internal transition source state '%s' and target state '%s' must be same.", t.source, t.target))
 	}
+	// 本地流转，一个状态必须是另一个状态的子孙状态
+	if t.ty == LOCAL && !t.target.isDescendantOf(t.source) && !t.source.isDescendantOf(t.target) {
+		return NewError(fmt.Sprintf("Local transition source state '%s' and target state '%s' must be a composite state and its substate.", t.source, t.target))
+	}
 	return nil
 }
 
@@ -60,6 +151,14 @@ func (t *Transition[S, E, C]) equals(o *Transition[S, E, C]) bool {
 	return t.event == o.event && t.source.equals(o.source) && t.target.equals(o.target)
 }
 
+// label 流转在状态图中的标签，格式为 event [guard]
+func (t *Transition[S, E, C]) label() string {
+	if t.conditionName == "" {
+		return fmt.Sprintf("%v", t.event)
+	}
+	return fmt.Sprintf("%v [%s]", t.event, t.conditionName)
+}
+
 func (t *Transition[S, E, C]) String() string {
 	return fmt.Sprintf("%s-[%v, %s]->%s", t.source, t.event, t.ty, t.target)
 }
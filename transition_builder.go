@@ -1,5 +1,10 @@
 package statemachine
 
+import (
+	"context"
+	"time"
+)
+
 type stateMap[S, E ID, C any] map[S]*state[S, E, C]
 
 func (s stateMap[S, E, C]) createAndGet(stateId S) *state[S, E, C] {
@@ -9,6 +14,19 @@ func (s stateMap[S, E, C]) createAndGet(stateId S) *state[S, E, C] {
 	return s[stateId]
 }
 
+func (s stateMap[S, E, C]) get(stateId S) *state[S, E, C] {
+	return s[stateId]
+}
+
+// sorted 按状态 id 排序返回所有状态
+func (s stateMap[S, E, C]) sorted() []*state[S, E, C] {
+	res := make([]*state[S, E, C], 0, len(s))
+	for _, state := range s {
+		res = append(res, state)
+	}
+	return sortStates(res)
+}
+
 func newTransitionBuilder[S, E ID, C any](stateMachine *stateMachine[S, E, C], transitionType TransitionType) *transitionBuilder[S, E, C] {
 	return &transitionBuilder[S, E, C]{
 		stateMachine:   stateMachine,
@@ -54,14 +72,76 @@ func (t *transitionBuilder[S, E, C]) On(event E) On[S, E, C] {
 	return t
 }
 
+func (t *transitionBuilder[S, E, C]) After(d time.Duration, event E) On[S, E, C] {
+	for _, source := range t.sources {
+		source.timeouts = append(source.timeouts, &timeout[E]{after: d, event: event})
+	}
+	t.On(event)
+	for _, transition := range t.transitions {
+		transition.after = d
+	}
+	return t
+}
+
 func (t *transitionBuilder[S, E, C]) When(condition Condition[C]) When[S, E, C] {
+	if condition == nil {
+		return t.WhenContext(nil)
+	}
+	return t.WhenContext(func(_ context.Context, c C) bool {
+		return condition(c)
+	})
+}
+
+func (t *transitionBuilder[S, E, C]) WhenNamed(name string, condition Condition[C]) When[S, E, C] {
+	t.When(condition)
+	for _, transition := range t.transitions {
+		transition.conditionName = name
+	}
+	return t
+}
+
+func (t *transitionBuilder[S, E, C]) WhenContext(condition ContextCondition[C]) When[S, E, C] {
 	for _, transition := range t.transitions {
 		transition.condition = condition
 	}
 	return t
 }
 
+func (t *transitionBuilder[S, E, C]) Use(interceptors ...Interceptor[S, E, C]) When[S, E, C] {
+	for _, transition := range t.transitions {
+		transition.interceptors = append(transition.interceptors, interceptors...)
+	}
+	return t
+}
+
 func (t *transitionBuilder[S, E, C]) Perform(action Action[S, E, C]) {
+	if action == nil {
+		t.PerformContext(nil)
+		return
+	}
+	t.PerformContext(func(_ context.Context, from S, to S, event E, c C) error {
+		return action(from, to, event, c)
+	})
+}
+
+func (t *transitionBuilder[S, E, C]) PerformNamed(name string, action Action[S, E, C]) {
+	t.Perform(action)
+	for _, transition := range t.transitions {
+		transition.actionName = name
+	}
+}
+
+func (t *transitionBuilder[S, E, C]) PerformPost(action PostAction[S, E, C]) {
+	if action == nil {
+		t.PerformContext(nil)
+		return
+	}
+	t.PerformContext(func(ctx context.Context, from S, to S, event E, c C) error {
+		return action(ctx, posterOf[E](ctx), from, to, event, c)
+	})
+}
+
+func (t *transitionBuilder[S, E, C]) PerformContext(action ContextAction[S, E, C]) {
 	for _, transition := range t.transitions {
 		transition.action = action
 	}
@@ -69,6 +149,7 @@ func (t *transitionBuilder[S, E, C]) Perform(action Action[S, E, C]) {
 
 var _ ExternalTransitionBuilder[int, int, int] = (*transitionBuilder[int, int, int])(nil)
 var _ InternalTransitionBuilder[int, int, int] = (*transitionBuilder[int, int, int])(nil)
+var _ LocalTransitionBuilder[int, int, int] = (*transitionBuilder[int, int, int])(nil)
 var _ From[int, int, int] = (*transitionBuilder[int, int, int])(nil)
 var _ To[int, int, int] = (*transitionBuilder[int, int, int])(nil)
 var _ On[int, int, int] = (*transitionBuilder[int, int, int])(nil)
@@ -0,0 +1,203 @@
+package statemachine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrValidation 状态机没有通过构建时的校验
+var ErrValidation = NewError("state machine validation failed")
+
+// ValidationKind 校验问题的类型
+type ValidationKind int
+
+const (
+	// UnreachableState 从起始状态出发无法到达的状态
+	UnreachableState ValidationKind = iota + 1
+	// DeadEndState 不是终止状态，但是没有流向其他状态的流转
+	DeadEndState
+	// MissingAction 流转没有设置动作
+	MissingAction
+	// AmbiguousTransition 同一个状态和事件上有多个没有条件的流转
+	AmbiguousTransition
+)
+
+func (k ValidationKind) String() string {
+	switch k {
+	case UnreachableState:
+		return "UnreachableState"
+	case DeadEndState:
+		return "DeadEndState"
+	case MissingAction:
+		return "MissingAction"
+	case AmbiguousTransition:
+		return "AmbiguousTransition"
+	}
+	return ""
+}
+
+// ValidationProblem 校验发现的一个问题，Event 只对 MissingAction 和 AmbiguousTransition 有效
+type ValidationProblem[S, E ID] struct {
+	Kind  ValidationKind
+	State S
+	Event E
+	Msg   string
+}
+
+func (p *ValidationProblem[S, E]) Error() string {
+	return fmt.Sprintf("%s: %s", p.Kind, p.Msg)
+}
+
+// ValidationError 校验发现的所有问题
+type ValidationError[S, E ID] struct {
+	MachineId string
+	Problems  []*ValidationProblem[S, E]
+}
+
+func (e *ValidationError[S, E]) Error() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("state machine [%s] validation failed with %d problems:", e.MachineId, len(e.Problems)))
+	for _, problem := range e.Problems {
+		builder.WriteString("\n  - " + problem.Error())
+	}
+	return builder.String()
+}
+
+func (e *ValidationError[S, E]) Unwrap() []error {
+	res := make([]error, 0, len(e.Problems)+1)
+	res = append(res, ErrValidation)
+	for _, problem := range e.Problems {
+		res = append(res, problem)
+	}
+	return res
+}
+
+type validationOptions[S ID] struct {
+	initial       *S
+	finals        map[S]bool
+	requireAction bool
+}
+
+// ValidationOption 构建时校验的选项
+type ValidationOption[S ID] func(o *validationOptions[S])
+
+// ValidateReachableFrom 校验从 initial 出发无法到达的状态，没有设置时使用 Builder 声明的起始状态
+func ValidateReachableFrom[S ID](initial S) ValidationOption[S] {
+	return func(o *validationOptions[S]) {
+		o.initial = &initial
+	}
+}
+
+// ValidateFinalStates 声明终止状态，校验其他没有流向其他状态的流转的状态，Builder 声明的终止状态也会参与校验
+func ValidateFinalStates[S ID](finals ...S) ValidationOption[S] {
+	return func(o *validationOptions[S]) {
+		for _, final := range finals {
+			o.finals[final] = true
+		}
+	}
+}
+
+// ValidateRequireAction 校验没有设置动作的流转
+func ValidateRequireAction[S ID]() ValidationOption[S] {
+	return func(o *validationOptions[S]) {
+		o.requireAction = true
+	}
+}
+
+// validate 校验状态机的结构，没有问题时返回 nil
+func (s *stateMachine[S, E, C]) validate(opts *validationOptions[S]) error {
+	var problems []*ValidationProblem[S, E]
+	states := s.stateMap.sorted()
+	initial := opts.initial
+	if initial == nil && s.initial != nil {
+		initial = &s.initial.id
+	}
+	finals := make(map[S]bool)
+	for final := range opts.finals {
+		finals[final] = true
+	}
+	for final := range s.finals {
+		finals[final] = true
+	}
+	if initial != nil {
+		reachable := s.reachableStates(*initial)
+		for _, state := range states {
+			if !reachable[state.id] {
+				problems = append(problems, &ValidationProblem[S, E]{
+					Kind:  UnreachableState,
+					State: state.id,
+					Msg:   fmt.Sprintf("state '%s' is unreachable from initial state '%v'", state, *initial),
+				})
+			}
+		}
+	}
+	if len(finals) != 0 {
+		for _, state := range states {
+			if len(state.children) == 0 && !finals[state.id] && !state.hasOutgoing() {
+				problems = append(problems, &ValidationProblem[S, E]{
+					Kind:  DeadEndState,
+					State: state.id,
+					Msg:   fmt.Sprintf("state '%s' is not a final state but has no outgoing transition", state),
+				})
+			}
+		}
+	}
+	if opts.requireAction {
+		for _, state := range states {
+			for _, transition := range state.getSortedEventTransitions() {
+				if transition.action == nil {
+					problems = append(problems, &ValidationProblem[S, E]{
+						Kind:  MissingAction,
+						State: state.id,
+						Event: transition.event,
+						Msg:   fmt.Sprintf("transition %s has no action", transition),
+					})
+				}
+			}
+		}
+	}
+	for _, state := range states {
+		unguarded := make(map[E]int)
+		for _, transition := range state.getSortedEventTransitions() {
+			if transition.condition != nil {
+				continue
+			}
+			unguarded[transition.event]++
+			if unguarded[transition.event] == 2 {
+				problems = append(problems, &ValidationProblem[S, E]{
+					Kind:  AmbiguousTransition,
+					State: state.id,
+					Event: transition.event,
+					Msg:   fmt.Sprintf("state '%s' has multiple transitions without condition on event '%v'", state, transition.event),
+				})
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError[S, E]{MachineId: s.machineId, Problems: problems}
+}
+
+// reachableStates 返回从 initial 出发可以到达的状态，到达子状态的同时也到达了它的父状态
+func (s *stateMachine[S, E, C]) reachableStates(initial S) map[S]bool {
+	reachable := make(map[S]bool)
+	var queue []*state[S, E, C]
+	visit := func(st *state[S, E, C]) {
+		for p := st; p != nil && !reachable[p.id]; p = p.parent {
+			reachable[p.id] = true
+			queue = append(queue, p)
+		}
+	}
+	if st := s.stateMap.get(initial); st != nil {
+		visit(st)
+	}
+	for len(queue) != 0 {
+		st := queue[0]
+		queue = queue[1:]
+		for _, transition := range st.getAllEventTransitions() {
+			visit(transition.target)
+		}
+	}
+	return reachable
+}